@@ -4,91 +4,147 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
+
+	"discord_clear_urls/clearurls"
 
 	"github.com/diamondburned/arikawa/v3/api"
 	"github.com/diamondburned/arikawa/v3/discord"
-	"github.com/diamondburned/arikawa/v3/gateway"
 	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/json/option"
+	"github.com/dlclark/regexp2"
 )
 
+var spoilerFinder = regexp2.MustCompile(`\|\|(\s*?[\s\S]+?\s*)\|\|`, regexp2.None)
+
+func enforceSpoilerPadding(src string) (string, error) {
+	return spoilerFinder.Replace(src, "|| $1 ||", -1, -1)
+}
+
+var connectedUrlFinder = regexp2.MustCompile(`https?:\/\/\S+?(?=https?:\/\/)`, regexp2.None)
+
+// var linebreaksFinder = regexp2.MustCompile(`\r?\n|\r`, regexp2.None)
+var maskedLinkFinder = regexp2.MustCompile(`\[((?!\s*\])[\s\S]+?)\]\([\s　]*(<)?(https?:\/\/(?(2)[^\s>]+|\S+))(?(2)>?)[\s　]*\)`, regexp2.None)
+
+func enforceMaskedLinkPadding(src string) (string, error) {
+	return maskedLinkFinder.Replace(src, "[$1]( $3 )", -1, -1)
+}
+
+var dcMaskFilter = regexp2.MustCompile(`https?:\/\/\S\S`, regexp2.None)
+
+// var spoilerExtractor = regexp2.MustCompile(`\|\|(\s*?[\s\S]+?\s*)\|\|`, regexp2.None)
+// var spoilerExtractor = regexp2.MustCompile(`\|\|\s*(.+?)\s*\|\|`, regexp2.None)
+
+var impureUrlsDetector = regexp2.MustCompile(`^(?!\s*(?:https?:\/\/\S+\.\S+\s*)+$).+`, regexp2.Multiline)
+
+// var impureUrlsDetector = regexp2.MustCompile(`^(?!\s*(?:(?:\s*\|\|)?\s*https?:\/\/\S+\.\S+\s*(?:\|\|\s*)?)+$).+`, regexp2.Multiline) // This version handles discord spoiler syntax ||
+// var urlOnlyDetector = regexp2.MustCompile(`^[^\S\r\n]*https?:\/\/\S+$`, regexp2.None)
+var urlExtractor = regexp2.MustCompile(`https?:\/\/\S+\.\S+`, regexp2.None)
+
+// var urlExtractor = regexp2.MustCompile(`(?:\|\|\s*)https?:\/\/\S+?\.[^\s|]+(?:\s*\|\|)|https?:\/\/\S+?\.[^\s|]+`, regexp2.None) // [^\s|]+ for Discord
+
+// var spoilerExtractor = regexp2.MustCompile(`(?<=\|\|\s*)https?:\/\/\S+(?=\s*\|\|)`, regexp2.None) // \|\|\s*(https?:\/\/\S+?)\s*\|\|
+
+// func Despoil(src string) string {
+// 	spoilerMatch, err := spoilerExtractor.FindStringMatch(src)
+// 	if err != nil {
+// 		return src
+// 	}
+// 	if spoilerMatch != nil {
+// 		return spoilerMatch.String()
+// 	}
+// 	return src
+// }
+
 type processedUrl struct {
 	Raw        string
 	Processed  string
 	IsSpoiler  bool
 	IsRedirect bool
+	IsBlocked  bool
 	Mask       string
 	IsSafe     bool
 }
 
-func IsUrlSafe(url string, data *Data) bool {
-	// Find provider
-	var provider *Provider
-	for _, p := range data.Providers {
-		if match, _ := p.UrlPattern.MatchString(url); match {
-			provider = &p
-			break
-		}
-		// Check aliases
-		for _, alias := range p.Aliases {
-			if match, _ := alias.MatchString(url); match {
-				provider = &p
-				break
-			}
-		}
-		if provider != nil {
-			break
-		}
-	}
-
-	if provider == nil {
-		return false
-	}
-
-	// Check params
-	paramMatch, err := paramExtracter.FindStringMatch(url)
-	if err != nil || paramMatch == nil {
-		return true // No params = safe (vacuously true for "all params match")
-	}
+// TryCleanMessage acts on the urls of message as configured for its channel, member is the author's if known.
+// By default the bot replies with the urls cleaned and warnings about them, URL only messages are deleted.
+func TryCleanMessage(message *discord.Message, member *discord.Member, data *clearurls.Data, s *state.State) {
+	cleanMessage(message, member, data, s, false)
+}
 
-	// Iterate all params
-	for paramMatch != nil {
-		paramName := paramMatch.GroupByNumber(1).String()
-		isParamSafe := false
-		if provider.SafeParameters != nil {
-			for _, safe := range provider.SafeParameters {
-				if match, _ := safe.MatchString(paramName); match {
-					isParamSafe = true
-					break
-				}
-			}
-		}
-		if !isParamSafe {
-			return false
-		}
-		paramMatch, _ = paramExtracter.FindNextMatch(paramMatch)
+// channelSettings returns the settings of the channel of message, ok is false if the bot should leave message alone
+func channelSettings(message *discord.Message, member *discord.Member) (settings ChannelConfig, ok bool) {
+	settings = config.Channel(message.GuildID, message.ChannelID)
+	var roleIDs []discord.RoleID
+	if member != nil {
+		roleIDs = member.RoleIDs
 	}
-	return true
+	return settings, settings.Enabled && !settings.IsExempt(message.Author.ID, roleIDs)
 }
 
-func TryCleanMessage(message *gateway.MessageCreateEvent, data *Data, s *state.State) {
+// cleanMessage is TryCleanMessage, edited messages were counted in the stats already and report nothing again
+func cleanMessage(message *discord.Message, member *discord.Member, data *clearurls.Data, s *state.State, edited bool) {
 	// Ignore bot messages
-	if message.Author.Bot || message == nil {
+	if message == nil || message.Author.Bot {
 		return
 	}
 
-	stats.TotalMessages++
+	settings, ok := channelSettings(message, member)
+	if !ok {
+		return
+	}
 
-	urlMap, cleaned, redirects, masks, notUrlOnly, err := TryCleanString(message.Content, data)
+	var report clearurls.Reporter
+	var traces *[]clearurls.Trace
+	if !edited {
+		report, traces = reportStats, &[]clearurls.Trace{}
+	}
+	urlMap, cleaned, redirects, masks, blocked, notUrlOnly, err := tryCleanString(message.Content, data, report, traces)
 	if err != nil {
 		log.Println("Failed to clean message:", err)
 		return
 	}
+	redirects, masks = applyWarningSettings(urlMap, settings, redirects, masks)
+	if len(urlMap) > 0 {
+		contents.Track(message.ID, message.Content)
+	}
+
+	acted := cleaned > 0 || redirects > 0 || masks > 0 || blocked > 0
+	if !edited {
+		stats.Add(StatsCounters{TotalMessages: 1})
+		counts := Counts{Messages: 1}
+		if acted {
+			stats.Add(StatsCounters{CleanedMessages: 1})
+			counts.CleanedMessages, counts.CleanedURLs, counts.Redirects, counts.Blocked = 1, cleaned, redirects, blocked
+		}
+		statsHistory.Record(message.GuildID, message.ChannelID, counts, *traces)
+	}
+	if !acted {
+		return
+	}
 
-	if cleaned == 0 && redirects == 0 && masks == 0 {
+	switch settings.Action {
+	case ACTION_SUPPRESS:
+		if cleaned > 0 || (redirects+blocked == len(urlMap)) {
+			err = suppressEmbeds(s, message)
+			if err != nil {
+				log.Printf("Failed to edit message: %v", err)
+			}
+		}
+		return
+	case ACTION_REACT:
+		reactToMessage(s, message, cleaned, redirects, masks, blocked)
 		return
 	}
 
-	stats.CleanedMessages++
+	// Reposting drops the warnings, leave those to a reply
+	if cleaned > 0 && redirects == 0 && masks == 0 && blocked == 0 && settings.Action == ACTION_REPOST {
+		err = RepostMessage(s, message, member, urlMap)
+		if err == nil {
+			return
+		}
+		log.Printf("Failed to repost message, replying instead: %v", err)
+	}
 
 	replyString := PrepareReply(urlMap)
 	log.Printf("---\n")
@@ -105,20 +161,13 @@ func TryCleanMessage(message *gateway.MessageCreateEvent, data *Data, s *state.S
 			GuildID:   message.GuildID,
 		},
 		Flags: discord.SuppressNotifications,
-		// Components: discord.Components (
-		// 	&discord.ButtonComponent{
-		// 		Label: "♻️",
-		// 		Style: discord.SecondaryButtonStyle,
-		// 		CustomID: "clean_message",
-		// 	},
-		// ),
 	}
 
 	if cleaned == 0 {
 		msgData.Flags = discord.SuppressNotifications | discord.SuppressEmbeds
 	}
 
-	deleting := !notUrlOnly && cleaned > 0 && redirects == 0
+	deleting := !notUrlOnly && cleaned > 0 && redirects == 0 && blocked == 0
 	if !deleting {
 		msgData.Reference = nil
 	}
@@ -142,15 +191,16 @@ func TryCleanMessage(message *gateway.MessageCreateEvent, data *Data, s *state.S
 		}
 	}
 
-	if len(urlMap) > 1 {
-		msgData.Content = fmt.Sprintf("%s:\n%s", message.Author.Mention(), replyString)
-	} else {
-		msgData.Content = fmt.Sprintf("%s: %s", message.Author.Mention(), replyString)
+	msgData.Content = replyContent(message.Author, urlMap, replyString)
+	if deleting {
+		msgData.Components = undoButton
 	}
 
 	newMsg, err := s.SendMessageComplex(message.ChannelID, msgData)
 	if err != nil {
 		log.Printf("Failed to reply: %v", err)
+	} else if !deleting {
+		replies.Track(message.ID, botReply{ChannelID: newMsg.ChannelID, ID: newMsg.ID, ContentHash: contentHash(message.Content), SentAt: time.Now()})
 	}
 	err = nil
 
@@ -159,21 +209,26 @@ func TryCleanMessage(message *gateway.MessageCreateEvent, data *Data, s *state.S
 		if err != nil {
 			log.Printf("Failed to delete message: %v", err)
 
-			_, err = s.EditMessage(newMsg.ChannelID, newMsg.ID, newMsg.Content+"\n-# 原訊息刪除失敗，請管理員確認管理訊息權限")
+			_, err = s.EditMessageComplex(newMsg.ChannelID, newMsg.ID, api.EditMessageData{
+				Content:    option.NewNullableString(newMsg.Content + "\n-# 原訊息刪除失敗，請管理員確認管理訊息權限"),
+				Components: &discord.ContainerComponents{},
+			})
 			if err != nil {
 				log.Printf("  Failed to edit message: %v", err)
 			}
+		} else if newMsg != nil {
+			deleted := deletedMessage{AuthorID: message.Author.ID, ChannelID: message.ChannelID, Content: message.Content, DeletedAt: time.Now()}
+			if msgData.Reference != nil && msgData.Reference.MessageID != message.ID {
+				deleted.ReplyTo = msgData.Reference
+			}
+			undos.Put(newMsg.ID, deleted)
 		}
 		err = nil
 		return
 	}
 
-	if cleaned > 0 || (redirects == len(urlMap)) {
-		edit := api.EditMessageData{}
-		edit.Flags = new(discord.MessageFlags)
-		*edit.Flags = message.Flags
-		*edit.Flags |= discord.SuppressEmbeds
-		_, err = s.EditMessageComplex(message.ChannelID, message.ID, edit)
+	if cleaned > 0 || (redirects+blocked == len(urlMap)) {
+		err = suppressEmbeds(s, message)
 		if err != nil {
 			log.Printf("Failed to edit message: %v", err)
 			_, err = s.EditMessage(newMsg.ChannelID, newMsg.ID, newMsg.Content+"\n-# 原訊息嵌入抑制失敗，請管理員確認管理訊息權限")
@@ -185,6 +240,144 @@ func TryCleanMessage(message *gateway.MessageCreateEvent, data *Data, s *state.S
 	}
 }
 
+// TryCleanEditedMessage follows an edit of message: the reply about it is edited to match the new content,
+// or deleted if nothing is left to clean. Messages the bot hasn't replied to are cleaned again if their content changed,
+// without counting them as new messages.
+func TryCleanEditedMessage(message *discord.Message, member *discord.Member, data *clearurls.Data, s *state.State) {
+	// Updates without an author only carry embeds, ignore them and bot messages
+	if message == nil || !message.Author.ID.IsValid() || message.Author.Bot {
+		return
+	}
+
+	reply, ok := replies.Get(message.ID)
+	if !ok {
+		if message.EditedTimestamp.IsValid() && contents.Changed(message.ID, message.Content) {
+			cleanMessage(message, member, data, s, true)
+		}
+		return
+	}
+	if reply.ContentHash == contentHash(message.Content) {
+		return // Only embeds or flags changed, e.g. suppressed by the bot
+	}
+
+	settings, ok := channelSettings(message, member)
+	if !ok {
+		return
+	}
+	if settings.Action == ACTION_SUPPRESS || settings.Action == ACTION_REACT {
+		// The channel doesn't want replies anymore, act on the edit like on a new message instead
+		DeleteReplyTo(s, message.ID)
+		cleanMessage(message, member, data, s, true)
+		return
+	}
+
+	urlMap, cleaned, redirects, masks, blocked, _, err := TryCleanString(message.Content, data)
+	if err != nil {
+		log.Println("Failed to clean edited message:", err)
+		return
+	}
+	redirects, masks = applyWarningSettings(urlMap, settings, redirects, masks)
+
+	replyString := ""
+	if cleaned > 0 || redirects > 0 || masks > 0 || blocked > 0 {
+		replyString = PrepareReply(urlMap)
+	}
+	if replyString == "" {
+		err = s.DeleteMessage(reply.ChannelID, reply.ID, "Nothing left to clean after an edit")
+		if err != nil {
+			log.Printf("Failed to delete reply: %v", err)
+			return
+		}
+		replies.Forget(message.ID)
+		return
+	}
+
+	edit := api.EditMessageData{
+		Content:         option.NewNullableString(replyContent(message.Author, urlMap, replyString)),
+		AllowedMentions: mentionNone,
+		Flags:           new(discord.MessageFlags),
+	}
+	*edit.Flags = discord.SuppressNotifications
+	if cleaned == 0 {
+		*edit.Flags |= discord.SuppressEmbeds
+	}
+	_, err = s.EditMessageComplex(reply.ChannelID, reply.ID, edit)
+	if err != nil {
+		log.Printf("Failed to edit reply: %v", err)
+		return
+	}
+	reply.ContentHash = contentHash(message.Content)
+	replies.Track(message.ID, reply)
+
+	if cleaned > 0 || (redirects+blocked == len(urlMap)) {
+		err = suppressEmbeds(s, message)
+		if err != nil {
+			log.Printf("Failed to edit message: %v", err)
+		}
+	}
+}
+
+// applyWarningSettings drops the warnings turned off in settings from urlMap and returns the counts left
+func applyWarningSettings(urlMap []processedUrl, settings ChannelConfig, redirects int, masks int) (int, int) {
+	if !settings.RedirectWarnings {
+		for i := range urlMap {
+			urlMap[i].IsRedirect = false
+		}
+		redirects = 0
+	}
+	if !settings.MaskWarnings {
+		for i := range urlMap {
+			if urlMap[i].Mask != "" {
+				urlMap[i].IsSafe = true
+			}
+		}
+		masks = 0
+	}
+	return redirects, masks
+}
+
+// reactToMessage marks what's wrong with message without replying
+func reactToMessage(s *state.State, message *discord.Message, cleaned int, redirects int, masks int, blocked int) {
+	var emojis []discord.APIEmoji
+	if cleaned > 0 {
+		emojis = append(emojis, "🧹")
+	}
+	if redirects > 0 {
+		emojis = append(emojis, "↪️")
+	}
+	if masks > 0 {
+		emojis = append(emojis, "↔️")
+	}
+	if blocked > 0 {
+		emojis = append(emojis, "⛔")
+	}
+	for _, emoji := range emojis {
+		err := s.React(message.ChannelID, message.ID, emoji)
+		if err != nil {
+			log.Printf("Failed to react: %v", err)
+			return
+		}
+	}
+}
+
+// replyContent is the reply to a message by author, mentioning them
+func replyContent(author discord.User, urlMap []processedUrl, replyString string) string {
+	if len(urlMap) > 1 {
+		return fmt.Sprintf("%s:\n%s", author.Mention(), replyString)
+	}
+	return fmt.Sprintf("%s: %s", author.Mention(), replyString)
+}
+
+// suppressEmbeds hides the embeds of message, which still show the tracked urls
+func suppressEmbeds(s *state.State, message *discord.Message) error {
+	edit := api.EditMessageData{}
+	edit.Flags = new(discord.MessageFlags)
+	*edit.Flags = message.Flags
+	*edit.Flags |= discord.SuppressEmbeds
+	_, err := s.EditMessageComplex(message.ChannelID, message.ID, edit)
+	return err
+}
+
 func PrepareReply(urlMap []processedUrl) string {
 	sb := strings.Builder{}
 
@@ -202,6 +395,11 @@ func PrepareReply(urlMap []processedUrl) string {
 				return sb.String()
 			}
 
+			if processedUrl.IsBlocked {
+				sb.WriteString("⛔ Tracking / 追蹤用網址，建議不要點擊")
+				return sb.String()
+			}
+
 			if processedUrl.Mask != "" && !processedUrl.IsSafe {
 
 				// strippedLink := strings.TrimPrefix(processedUrl.Processed, "https://")
@@ -227,7 +425,7 @@ func PrepareReply(urlMap []processedUrl) string {
 	}
 
 	for _, processedUrl := range urlMap {
-		if cleaned == 0 && processedUrl.Processed == processedUrl.Raw && (processedUrl.Mask == "" || processedUrl.IsSafe) && !processedUrl.IsRedirect { // Nothing wrong with the message and this url
+		if cleaned == 0 && processedUrl.Processed == processedUrl.Raw && (processedUrl.Mask == "" || processedUrl.IsSafe) && !processedUrl.IsRedirect && !processedUrl.IsBlocked { // Nothing wrong with the message and this url
 			continue
 		}
 
@@ -256,6 +454,9 @@ func PrepareReply(urlMap []processedUrl) string {
 		if processedUrl.IsRedirect {
 			sb.WriteString(" ↪️ Redirect / 重導向網址，可能是任何站點")
 		}
+		if processedUrl.IsBlocked {
+			sb.WriteString(" ⛔ Tracking / 追蹤用網址，建議不要點擊")
+		}
 		sb.WriteRune('\n')
 	}
 
@@ -270,7 +471,77 @@ func PrepareReply(urlMap []processedUrl) string {
 	return replyString
 }
 
-func TryCleanString(str string, data *Data) (urlMap []processedUrl, cleaned int, redirects int, masks int, notUrlOnly bool, err error) {
+// MAX_REPLY_LENGTH is the most Discord allows in a message
+const MAX_REPLY_LENGTH = 2000
+
+// ExplainReply tells step by step how url is cleaned, for the /explain command
+func ExplainReply(url string, data *clearurls.Data) string {
+	url = strings.TrimSpace(url)
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "❓ Not a url / 不是網址"
+	}
+	result := explainUrl(url, data)
+
+	sb := strings.Builder{}
+	if result.Processed == result.Url {
+		sb.WriteString("✅ Nothing to clean / 沒有需要清理的內容")
+	} else {
+		sb.WriteString(result.Processed)
+	}
+	if result.IsRedirect {
+		sb.WriteString(" ↪️ Redirect / 重導向網址，可能是任何站點")
+	}
+	if result.IsBlocked {
+		sb.WriteString(" ⛔ Tracking / 追蹤用網址，建議不要點擊")
+	}
+	sb.WriteString("\n```\n")
+
+	const truncated = "…\n```"
+	for _, step := range result.Steps {
+		line := strings.ReplaceAll(step.String(), "```", "`\u200b``") + "\n"
+		if sb.Len()+len(line)+len(truncated) > MAX_REPLY_LENGTH {
+			sb.WriteString(truncated)
+			return sb.String()
+		}
+		sb.WriteString(line)
+	}
+	sb.WriteString("```")
+	return sb.String()
+}
+
+// CLEAN_MESSAGE_COMMAND is the name of the message command cleaning the urls of any message
+const CLEAN_MESSAGE_COMMAND = "Clean this message"
+
+// CleanMessageReply lists the cleaned urls of any message, for the message command run by anyone.
+// Unlike TryCleanMessage it doesn't skip bots, webhooks or the channel settings.
+func CleanMessageReply(message discord.Message, data *clearurls.Data) string {
+	urlMap, cleaned, redirects, masks, blocked, _, err := TryCleanString(message.Content, data)
+	if err != nil {
+		log.Println("Failed to clean message:", err)
+		return "(*´･д･)? It failed... \\ 不知道為什麼失敗了..."
+	}
+	if cleaned == 0 && redirects == 0 && masks == 0 && blocked == 0 {
+		return "✅ Nothing to clean / 沒有需要清理的內容"
+	}
+	reply := PrepareReply(urlMap)
+	if len(reply) > MAX_REPLY_LENGTH {
+		// Cut at the last url which fits
+		cut := strings.LastIndex(reply[:MAX_REPLY_LENGTH-len("\n…")], "\n")
+		if cut < 0 {
+			return "⚠️ Too long to show / 太長無法顯示"
+		}
+		reply = reply[:cut] + "\n…"
+	}
+	return reply
+}
+
+func TryCleanString(str string, data *clearurls.Data) (urlMap []processedUrl, cleaned int, redirects int, masks int, blocked int, notUrlOnly bool, err error) {
+	return tryCleanString(str, data, nil, nil)
+}
+
+// tryCleanString is TryCleanString sending the events of cleaning to report unless it is nil,
+// the traces of the urls it cleaned or warns about are added to traces unless it is nil
+func tryCleanString(str string, data *clearurls.Data, report clearurls.Reporter, traces *[]clearurls.Trace) (urlMap []processedUrl, cleaned int, redirects int, masks int, blocked int, notUrlOnly bool, err error) {
 
 	str, err = connectedUrlFinder.Replace(str, "$& ", -1, -1)
 	if err != nil {
@@ -317,7 +588,14 @@ urlLoop:
 
 		matched := urlMatch.String()
 
-		processed, is_redirect := CleanUrl(matched, data)
+		var result clearurls.Result
+		var trace clearurls.Trace
+		if report != nil || traces != nil {
+			result, trace = data.CleanUrlTraced(matched, report)
+		} else {
+			result = data.CleanUrl(matched)
+		}
+		processed, is_redirect, is_blocked := result.Processed, result.IsRedirect, result.IsBlocked
 
 		if cleanedLookup == nil {
 			cleanedLookup = make(map[string]string)
@@ -327,6 +605,10 @@ urlLoop:
 				redirects++
 				log.Printf("\nFound Redirect: %s", matched)
 			}
+			if is_blocked {
+				blocked++
+				log.Printf("\nFound Tracking URL: %s", matched)
+			}
 
 			if processed != urlMatch.String() {
 				cleaned++
@@ -337,13 +619,16 @@ urlLoop:
 				urlMap = make([]processedUrl, 0, 3)
 			}
 
-			result := processedUrl{Raw: matched, Processed: processed, IsSpoiler: false, IsRedirect: is_redirect}
+			result := processedUrl{Raw: matched, Processed: processed, IsSpoiler: false, IsRedirect: is_redirect, IsBlocked: is_blocked}
 			for _, url := range urlMap {
 				if url.Raw == matched {
 					break urlLoop
 				}
 			}
 			urlMap = append(urlMap, result)
+			if traces != nil && (processed != matched || is_redirect || is_blocked) {
+				*traces = append(*traces, trace)
+			}
 		}
 
 		// Move to the next match (URL)
@@ -370,7 +655,7 @@ urlLoop:
 				log.Println("Failed to check if mask is a Discord mask:", err)
 			} else if !filtered && maskedMatch.GroupByNumber(3).String() == it.Raw { // Found the matching url
 				it.Mask = mask
-				if IsUrlSafe(it.Raw, data) {
+				if data.IsUrlSafe(it.Raw) {
 					it.IsSafe = true
 				} else {
 					masks++
@@ -386,7 +671,7 @@ urlLoop:
 		}
 	}
 
-	if cleaned == 0 && redirects == 0 && masks == 0 {
+	if cleaned == 0 && redirects == 0 && masks == 0 && blocked == 0 {
 		return
 	}
 
@@ -417,110 +702,6 @@ urlLoop:
 	return
 }
 
-func CleanUrl(url string, data *Data) (processed string, is_redirect bool) {
-
-	processed = url
-
-	// Loop through each provider
-	for _, provider := range data.Providers {
-		processed, is_redirect = applyRules(provider, processed, is_redirect)
-		if processed != url {
-			break
-		}
-	}
-
-	// Always apply global rules
-	processed, is_redirect = applyRules(data.GlobalRules, processed, is_redirect)
-
-	if processed != url {
-		stats.CleanedURLs++
-		if len(processed) > 0 && processed[len(processed)-1] == '?' {
-			processed = processed[:len(processed)-1]
-		}
-	}
-
-	return processed, is_redirect
-}
-
-func applyRules(provider Provider, url string, is_redirect bool) (string, bool) {
-
-	if match, _ := provider.UrlPattern.MatchString(url); !match {
-
-		i := len(provider.Aliases)
-		if provider.Aliases != nil {
-			for _, alias := range provider.Aliases {
-				if aliasMatch, _ := alias.MatchString(url); !aliasMatch {
-					i -= 1
-				}
-			}
-		}
-		if i == 0 {
-			return url, is_redirect
-		}
-
-	}
-
-	for _, rdr := range provider.Redirections {
-		if ridrectFound, _ := rdr.MatchString(url); ridrectFound {
-			stats.Redirects++
-			is_redirect = true
-			continue
-		}
-	}
-
-	exceptionFound := false
-	for _, exception := range provider.Exceptions {
-		if exceptionMatch, _ := exception.MatchString(url); exceptionMatch {
-			exceptionFound = true
-			break
-		}
-	}
-	if exceptionFound {
-		return url, is_redirect
-	}
-
-	paramMatch, err := paramExtracter.FindStringMatch(url)
-	if err != nil {
-		log.Println("Failed to find parameters in URL:", err)
-		return url, is_redirect
-	}
-
-	for paramMatch != nil {
-		stats.TotalParams++
-		var matchedParam string = paramMatch.String()
-		paramName := paramMatch.GroupByNumber(1).String()
-
-		ignore := false
-		for _, ignored := range provider.IgnoredParameters {
-			if match, _ := ignored.MatchString(paramName); match {
-				ignore = true
-				break
-			}
-		}
-		if !ignore {
-			for _, rule := range provider.Rules {
-				if match, _ := rule.MatchString(paramName); match {
-
-					if strings.HasPrefix(matchedParam, "&") {
-						url = strings.Replace(url, matchedParam, "", 1)
-					} else if strings.HasPrefix(matchedParam, "?") {
-						url = strings.Replace(url, matchedParam, "?", 1)
-					}
-
-					stats.CleanedParams++
-					break
-				}
-			}
-		}
-
-		paramMatch, err = paramExtracter.FindNextMatch(paramMatch)
-		if err != nil {
-			log.Println("Failed to find next parameter in URL:", err)
-		}
-	}
-	return url, is_redirect
-}
-
 // cleanTrackingParams removes tracking parameters from any URLs in the message
 // CleanMessageAndReport function that processes a message string and cleans up URLs based on providers' rules
 // func CleanMessageAndReport(message string, data *Data) string {
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/diamondburned/arikawa/v3/discord"
 )
 
 func TestTryCleanString(t *testing.T) {
@@ -16,6 +22,7 @@ func TestTryCleanString(t *testing.T) {
 		wantCleaned    int
 		wantRedirects  int
 		wantMasks      int
+		wantBlocked    int
 		wantNotUrlOnly bool
 		wantErr        bool
 		solo           bool
@@ -48,7 +55,7 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI ||  https://www.you
 			wantUrlMap: []processedUrl{
 				{
 					Raw:       "https://fixvx.com/belmond_b_2434/status/1851970896631861576?t=UD6n89jD4GoHSCFNkPsHbA&s=19",
-					Processed: "https://fixvx.com/belmond_b_2434/status/1851970896631861576?&s=19",
+					Processed: "https://fixvx.com/belmond_b_2434/status/1851970896631861576?s=19",
 					IsSpoiler: false,
 				},
 			},
@@ -151,7 +158,7 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI ||  https://www.you
 				}, // V
 				{
 					Raw:       "https://news.ltn.com.tw/news/life/breakingnews/4826075?fbclid=IwZXh0bgNhZW0CMTEAAR21sLbgLCKNGg1qFqOHPkGnKiINqzN3MyT1gtfuBY6Tlph-iIu06J5bgD4_aem_9oBjNcuqObVpJ-8towvPIA&prev=1",
-					Processed: "https://news.ltn.com.tw/news/life/breakingnews/4826075?&prev=1",
+					Processed: "https://news.ltn.com.tw/news/life/breakingnews/4826075?prev=1",
 					IsSpoiler: false,
 				}, // V
 			},
@@ -169,13 +176,13 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI ||  https://www.you
 			wantUrlMap: []processedUrl{
 				{
 					Raw:        "https://www.youtube.com/redirect?event=video_description&redir_token=QUFFLUhqbUlwZ3hybmEyZnd5bnpTR0N5VWFnN3J4MFE1Z3xBQ3Jtc0trY2tQMzA1NDdCcnphVm5oMGlfYVB1TU5VYjZaYVZSUGFzak1hLTJ2SGN1MkZCdmx1VU9zY1l3Tl91cXpuc19yVTBZYVhNTGdzMEtDaUJjX0lXaHJSYUtvdFNiQjBGV0NkRzBvUjZXejhFblVIRV93OA&q=https%3A%2F%2Fx.com%2Fi%2Fspaces%2F1lPKqOyrXWLJb&v=eqVjAWxlxbk",
-					Processed:  "https://www.youtube.com/redirect?event=video_description&redir_token=QUFFLUhqbUlwZ3hybmEyZnd5bnpTR0N5VWFnN3J4MFE1Z3xBQ3Jtc0trY2tQMzA1NDdCcnphVm5oMGlfYVB1TU5VYjZaYVZSUGFzak1hLTJ2SGN1MkZCdmx1VU9zY1l3Tl91cXpuc19yVTBZYVhNTGdzMEtDaUJjX0lXaHJSYUtvdFNiQjBGV0NkRzBvUjZXejhFblVIRV93OA&q=https%3A%2F%2Fx.com%2Fi%2Fspaces%2F1lPKqOyrXWLJb&v=eqVjAWxlxbk",
+					Processed:  "https://x.com/i/spaces/1lPKqOyrXWLJb",
 					IsSpoiler:  false,
-					IsRedirect: true,
+					IsRedirect: false,
 				},
 			},
-			wantCleaned:    0,
-			wantRedirects:  1,
+			wantCleaned:    1,
+			wantRedirects:  0,
 			wantMasks:      0,
 			wantNotUrlOnly: false,
 			wantErr:        false,
@@ -251,7 +258,7 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI ||  https://www.you
 			wantUrlMap: []processedUrl{
 				{
 					Raw:        "https://youtu.be/ybZOGIOy734?si=jP9GtZ88VWv_LaWb&t=359",
-					Processed:  "https://youtu.be/ybZOGIOy734?&t=359",
+					Processed:  "https://youtu.be/ybZOGIOy734?t=359",
 					IsSpoiler:  true,
 					IsRedirect: false,
 				},
@@ -282,7 +289,7 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI ||  https://www.you
 			wantErr:        false,
 		},
 	}
-	providers, err := FetchAndLoadRules(repo)
+	providers, err := clearurls.FetchAndLoadRules(repo)
 	if err != nil {
 		t.Fatalf("FetchAndLoadJSON() error = %v", err)
 	}
@@ -297,7 +304,7 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI ||  https://www.you
 			continue
 		}
 		t.Run(tt.name, func(t *testing.T) {
-			gotUrlMap, gotCleaned, gotRedirects, gotMasks, gotNotUrlOnly, err := TryCleanString(tt.args.str, providers)
+			gotUrlMap, gotCleaned, gotRedirects, gotMasks, gotBlocked, gotNotUrlOnly, err := TryCleanString(tt.args.str, providers)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("TryCleanString() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -314,6 +321,9 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI ||  https://www.you
 			if gotMasks != tt.wantMasks {
 				t.Errorf("TryCleanString() gotMasks = %v, want %v", gotMasks, tt.wantMasks)
 			}
+			if gotBlocked != tt.wantBlocked {
+				t.Errorf("TryCleanString() gotBlocked = %v, want %v", gotBlocked, tt.wantBlocked)
+			}
 			if gotNotUrlOnly != tt.wantNotUrlOnly {
 				t.Errorf("TryCleanString() gotNotUrlOnly = %v, want %v", gotNotUrlOnly, tt.wantNotUrlOnly)
 			}
@@ -423,31 +433,83 @@ https://www.youtube.com/redirect?event=video_description&redir_token=QUFFLUhqbUl
 	}
 }
 
-func TestCleanUrl(t *testing.T) {
+func TestExplainReply(t *testing.T) {
+	data := loadTestRules(t)
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"notUrl", "example.com", "❓ Not a url / 不是網址"},
+		{"nothingToClean", "https://example.com/a", "✅ Nothing to clean / 沒有需要清理的內容\n```\n" +
+			"example: matched https://example.com/a by ^https?:\\/\\/example\\.com (rules.json)\n" +
+			"globalRules: matched https://example.com/a by .* (rules.json)\n```"},
+		{"cleaned", " https://example.com/a?ref=x ", "https://example.com/a\n```\n" +
+			"example: matched https://example.com/a?ref=x by ^https?:\\/\\/example\\.com (rules.json)\n" +
+			"example: removed ref by ref (rules.json)\n" +
+			"globalRules: matched https://example.com/a by .* (rules.json)\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExplainReply(tt.url, data); got != tt.want {
+				t.Errorf("ExplainReply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	long := "https://example.com/a?" + strings.Repeat("ref=x&", 200)
+	if got := ExplainReply(long, data); len(got) > MAX_REPLY_LENGTH || !strings.HasSuffix(got, "…\n```") {
+		t.Errorf("ExplainReply() of a long trace is %d bytes ending with %q", len(got), got[len(got)-10:])
+	}
+}
+
+func TestCleanMessageReply(t *testing.T) {
+	data := loadTestRules(t)
+
 	tests := []struct {
 		name    string
-		message string
+		message discord.Message
 		want    string
 	}{
-		{"test", "https://x.com/4009_0825900/status/1840979404572213471?t=iy49kBSlrMutQ0QwNW4YyA&s=19", "https://x.com/4009_0825900/status/1840979404572213471?&s=19"},
-		{"test", "https://youtu.be/aVpJGGQHSqc?si=az72VbWhlionVl4c", "https://youtu.be/aVpJGGQHSqc"},
-		{"test", "https://www.youtube.com/watch?v=n-su1KVKlGk", "https://www.youtube.com/watch?v=n-su1KVKlGk"},
-		{"test", "https://www.reddit.com/r/MechanicalKeyboards/comments/156he48/attention_new_issue_with_gmk_keycaps_know_before/", "https://www.reddit.com/r/MechanicalKeyboards/comments/156he48/attention_new_issue_with_gmk_keycaps_know_before/"},
-		{"test", "https://travel.ettoday.net/amp/amp_news.php7?news_id=2738515&ref=mw&from=google.com", "https://travel.ettoday.net/amp/amp_news.php7?news_id=2738515"},
-		{"test", "https://www.china-airlines.com/zh-tw/tpe_20240906_autumn?gad_source=1&gclid=Cj0KCQjwo8S3BhDeARIsAFRmkOPMStVGw370iMxB8F3mTp9CB2ZgPRWsc2B1I5rkczwm_fcGrYrkD5EaAmDHEALw_wcB", "https://www.china-airlines.com/zh-tw/tpe_20240906_autumn"},
-		{"test", "https://www.tomtoc.com.tw/t21s1d2?_gl=1*1j8iyju*_up*MQ..&gclid=EAIaIQobChMImJvOisbaiAMVYcRMAh3OZSGtEAAYASAAEgJcVPD_BwE", "https://www.tomtoc.com.tw/t21s1d2"},
-		{"test", "https://cathaybk.com.tw/cathaybk/personal/product/credit-card/cards/eva/?CUB_SRC=GOOGLE&CUB_CHL1=AD_WORD&CUB_CHL2=01&MA_TK=DB590&CUB_DT=20240101&Cub_ProjectCode=DBB4400001&gad_source=1&gclid=Cj0KCQjw9Km3BhDjARIsAGUb4nygrkAZpfoCJo3YVMkZsSfpMtF8I2aoAy22EAOp8REOOeSlSd5r5d0aAk6zEALw_wcB", "https://cathaybk.com.tw/cathaybk/personal/product/credit-card/cards/eva/?CUB_SRC=GOOGLE&CUB_CHL1=AD_WORD&CUB_CHL2=01&MA_TK=DB590&CUB_DT=20240101&Cub_ProjectCode=DBB4400001"},
-		{"test", "https://m.momoshop.com.tw/goods.momo?i_code=10489628&osm=Ad07&utm_source=googleshop&utm_medium=googleshop-pmax-all-mb-feed&utm_content=bn&gclid=Cj0KCQjwwae1BhC_ARIsAK4Jfrw5xTuyBtdUOafMEZCD3bV0d6H77it_bp5Zi0UrzXjK69ztk3Z_hXgaAiFVEALw_wcB", "https://m.momoshop.com.tw/goods.momo?i_code=10489628"},
-	}
-	providers, err := FetchAndLoadRules(repo)
-	if err != nil {
-		t.Fatalf("FetchAndLoadJSON() error = %v", err)
+		{"user", discord.Message{Content: "look https://example.com/a?ref=x"}, "https://example.com/a"},
+		{"bot", discord.Message{Content: "https://example.com/a?ref=x", Author: discord.User{Bot: true}}, "https://example.com/a"},
+		{"webhook", discord.Message{Content: "https://example.com/a?ref=x https://other.org/?utm_source=y", WebhookID: 1},
+			"https://example.com/a\nhttps://other.org/"},
+		{"clean", discord.Message{Content: "https://example.com/a"}, "✅ Nothing to clean / 沒有需要清理的內容"},
+		{"noUrl", discord.Message{Content: "hello"}, "✅ Nothing to clean / 沒有需要清理的內容"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got, _ := CleanUrl(tt.message, providers); got != tt.want {
-				t.Errorf("Got= %v, want %v", got, tt.want)
+			if got := CleanMessageReply(tt.message, data); got != tt.want {
+				t.Errorf("CleanMessageReply() = %v, want %v", got, tt.want)
 			}
 		})
 	}
+
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, "https://example.com/%d?ref=x ", i)
+	}
+	if got := CleanMessageReply(discord.Message{Content: sb.String()}, data); len(got) > MAX_REPLY_LENGTH || !strings.HasSuffix(got, "\n…") {
+		t.Errorf("CleanMessageReply() of many urls is %d bytes ending with %q", len(got), got[len(got)-10:])
+	}
+}
+
+func TestCleanMessageEdited(t *testing.T) {
+	data := loadTestRules(t)
+	savedStats, savedHistory := stats, statsHistory
+	t.Cleanup(func() { stats, statsHistory = savedStats, savedHistory })
+	stats, statsHistory = &Stats{}, NewStatsHistory(1)
+
+	// Nothing to clean, so the bot doesn't act and needs no session
+	message := &discord.Message{ID: 1, ChannelID: 10, GuildID: 1, Content: "https://example.com/a?id=1"}
+	cleanMessage(message, nil, data, nil, true)
+	if got := stats.Counters(); got != (StatsCounters{}) {
+		t.Errorf("stats after an edit = %+v, want nothing counted", got)
+	}
+	cleanMessage(message, nil, data, nil, false)
+	if got := stats.Counters(); got != (StatsCounters{TotalMessages: 1, TotalParams: 2}) {
+		t.Errorf("stats after a new message = %+v, want the message and its 2 params checked", got)
+	}
 }
@@ -0,0 +1,324 @@
+package clearurls
+
+import (
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+)
+
+// IsUrlSafe tells if every param of the url is a safeParameter of the provider matching it,
+// masked links to such urls are not worth a warning
+func (d *Data) IsUrlSafe(url string) bool {
+	// Find provider
+	var provider *Provider
+	for _, p := range d.candidates(url) {
+		if match, _ := p.UrlPattern.MatchString(url); match {
+			provider = &p
+			break
+		}
+		// Check aliases
+		for _, alias := range p.Aliases {
+			if match, _ := alias.MatchString(url); match {
+				provider = &p
+				break
+			}
+		}
+		if provider != nil {
+			break
+		}
+	}
+
+	if provider == nil {
+		return false
+	}
+
+	// Check params, no params = safe (vacuously true for "all params match")
+	parsed := parseUrl(url)
+	for _, param := range append(parsed.Params, parsed.FragmentParams...) {
+		if param.Raw == "" {
+			continue
+		}
+		if !matchesAnyRule(provider.SafeParameters, param.Key) {
+			return false
+		}
+	}
+	return true
+}
+
+const maxRedirectDepth = 5
+
+// Result is what cleaning a single url ended up with
+type Result struct {
+	Url        string // The url as given
+	Processed  string
+	IsRedirect bool // The url redirects somewhere that couldn't be unwrapped, it may lead anywhere
+	IsBlocked  bool // The whole url is a tracker
+}
+
+// Cleaned tells if anything was removed from the url or it was unwrapped
+func (r Result) Cleaned() bool {
+	return r.Processed != r.Url
+}
+
+// CleanUrl removes tracking from a single url, redirects are unwrapped to their destination first.
+// Nothing is reported, see CleanUrlTraced.
+func (d *Data) CleanUrl(url string) Result {
+	return d.cleanAndReport(url, nil)
+}
+
+// cleanAndReport is CleanUrl, every step taken is added to trace unless it is nil
+func (d *Data) cleanAndReport(url string, trace *Trace) Result {
+	processed, is_redirect, is_blocked := d.cleanUrl(url, 0, trace)
+	if processed != url {
+		d.reportEvent(EventUrlCleaned)
+	}
+	return Result{Url: url, Processed: processed, IsRedirect: is_redirect, IsBlocked: is_blocked}
+}
+
+// cleanUrl does the work of CleanUrl, every step taken is added to trace unless it is nil
+func (d *Data) cleanUrl(url string, depth int, trace *Trace) (processed string, is_redirect bool, is_blocked bool) {
+
+	// Unwrap redirects to the real destination and clean that instead
+	if depth < maxRedirectDepth {
+		if target, provider, rule, ok := d.findRedirectTarget(url); ok {
+			d.reportEvent(EventRedirect)
+			d.addStep(trace, Step{Kind: STEP_UNWRAPPED, Provider: provider, Url: url, Target: target}, rule)
+			return d.cleanUrl(target, depth+1, trace)
+		}
+	}
+
+	processed = url
+
+	// Loop through each provider that may match, in priority order.
+	// The first one to change the url wins, unless it only matched through an alias:
+	// then the other targets of that alias get their turn too.
+	var via *regexp2.Regexp
+	for _, provider := range d.candidates(url) {
+		if via != nil && !hasAlias(provider, via) {
+			continue
+		}
+		before := processed
+		processed, is_redirect, is_blocked = d.applyRules(provider, processed, is_redirect, trace)
+		if is_blocked {
+			break
+		}
+		if via == nil && processed != before {
+			via = matchingAlias(provider, before)
+			if via == nil {
+				break
+			}
+		}
+	}
+
+	// Always apply global rules
+	var globalBlocked bool
+	processed, is_redirect, globalBlocked = d.applyRules(d.GlobalRules, processed, is_redirect, trace)
+	is_blocked = is_blocked || globalBlocked
+
+	if processed != url {
+		if len(processed) > 0 && processed[len(processed)-1] == '?' {
+			processed = processed[:len(processed)-1]
+		}
+	}
+
+	return processed, is_redirect, is_blocked
+}
+
+// findRedirectTarget returns the destination captured by the first matching redirection rule,
+// the provider it belongs to and the rule itself
+func (d *Data) findRedirectTarget(url string) (string, string, *regexp2.Regexp, bool) {
+	for _, provider := range d.candidates(url) {
+		if target, rule, ok := providerRedirectTarget(provider, url); ok {
+			return target, provider.Name, rule, true
+		}
+	}
+	target, rule, ok := providerRedirectTarget(d.GlobalRules, url)
+	return target, d.GlobalRules.Name, rule, ok
+}
+
+func providerRedirectTarget(provider Provider, url string) (string, *regexp2.Regexp, bool) {
+	if len(provider.Redirections) == 0 || !matchesProvider(provider, url) {
+		return "", nil, false
+	}
+
+	for _, exception := range provider.Exceptions {
+		if exceptionMatch, _ := exception.MatchString(url); exceptionMatch {
+			return "", nil, false
+		}
+	}
+
+	for _, rdr := range provider.Redirections {
+		rdrMatch, err := rdr.FindStringMatch(url)
+		if err != nil || rdrMatch == nil {
+			continue
+		}
+		group := rdrMatch.GroupByNumber(1)
+		if group == nil || group.Length == 0 {
+			continue // No capture group, can only be flagged
+		}
+		if target, ok := decodeRedirectTarget(group.String()); ok {
+			return target, rdr, true
+		}
+	}
+	return "", nil, false
+}
+
+// decodeRedirectTarget decodes the captured destination like decodeURIComponent does
+func decodeRedirectTarget(captured string) (string, bool) {
+	target, err := url.PathUnescape(captured)
+	if err != nil {
+		return "", false
+	}
+	if !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "http://") {
+		return "", false
+	}
+	return target, true
+}
+
+// matchingAlias returns the alias pattern the url matches the provider through, nil if it matches the urlPattern itself
+func matchingAlias(provider Provider, url string) *regexp2.Regexp {
+	if match, _ := provider.UrlPattern.MatchString(url); match {
+		return nil
+	}
+	for _, alias := range provider.Aliases {
+		if aliasMatch, _ := alias.MatchString(url); aliasMatch {
+			return alias
+		}
+	}
+	return nil
+}
+
+// hasAlias tells if the provider is a target of the alias, aliases share one compiled pattern among their targets
+func hasAlias(provider Provider, alias *regexp2.Regexp) bool {
+	for _, a := range provider.Aliases {
+		if a == alias {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesProvider(provider Provider, url string) bool {
+	if match, _ := provider.UrlPattern.MatchString(url); match {
+		return true
+	}
+	for _, alias := range provider.Aliases {
+		if aliasMatch, _ := alias.MatchString(url); aliasMatch {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Data) applyRules(provider Provider, url string, is_redirect bool, trace *Trace) (string, bool, bool) {
+
+	if !matchesProvider(provider, url) {
+		return url, is_redirect, false
+	}
+	if trace != nil {
+		matched := matchingAlias(provider, url)
+		if matched == nil {
+			matched = provider.UrlPattern
+		}
+		d.addStep(trace, Step{Kind: STEP_MATCHED, Provider: provider.Name, Url: url}, matched)
+	}
+
+	for _, rdr := range provider.Redirections {
+		if ridrectFound, _ := rdr.MatchString(url); ridrectFound {
+			d.reportEvent(EventRedirect)
+			d.addStep(trace, Step{Kind: STEP_REDIRECT, Provider: provider.Name, Url: url}, rdr)
+			is_redirect = true
+			continue
+		}
+	}
+
+	for _, exception := range provider.Exceptions {
+		if exceptionMatch, _ := exception.MatchString(url); exceptionMatch {
+			d.addStep(trace, Step{Kind: STEP_EXCEPTION, Provider: provider.Name, Url: url}, exception)
+			return url, is_redirect, false
+		}
+	}
+
+	// The whole url is a tracker, nothing to clean
+	if provider.CompleteProvider {
+		d.reportEvent(EventBlocked)
+		d.addStep(trace, Step{Kind: STEP_BLOCKED, Provider: provider.Name, Url: url}, nil)
+		return url, is_redirect, true
+	}
+
+	// Raw rules work on the whole url (e.g. /ref=... path segments)
+	for _, rawRule := range provider.RawRules {
+		replaced, err := rawRule.Replace(url, "", -1, -1)
+		if err != nil {
+			log.Println("Failed to apply raw rule:", err)
+			continue
+		}
+		if replaced != url {
+			d.addStep(trace, Step{Kind: STEP_RAW_RULE, Provider: provider.Name, Url: url}, rawRule)
+		}
+		url = replaced
+	}
+
+	rules := provider.Rules
+	if d.StripReferralMarketing && len(provider.ReferralMarketing) > 0 {
+		rules = make([]*regexp2.Regexp, 0, len(provider.Rules)+len(provider.ReferralMarketing))
+		rules = append(rules, provider.Rules...)
+		rules = append(rules, provider.ReferralMarketing...)
+	}
+
+	parsed := parseUrl(url)
+	var removedQuery, removedFragment bool
+	parsed.Params, removedQuery = d.filterParams(provider, rules, parsed.Params, trace)
+	if parsed.HasFragmentQuery {
+		// Fragment params are checked against the normal rules plus the fragment only ones
+		fragmentRules := append(rules[:len(rules):len(rules)], provider.FragmentRules...)
+		parsed.FragmentParams, removedFragment = d.filterParams(provider, fragmentRules, parsed.FragmentParams, trace)
+	}
+
+	if removedQuery || removedFragment {
+		url = parsed.String()
+	}
+	return url, is_redirect, false
+}
+
+// filterParams returns the params not matching any rule, ignored parameters are always kept
+func (d *Data) filterParams(provider Provider, rules []*regexp2.Regexp, params []queryParam, trace *Trace) (kept []queryParam, removed bool) {
+	kept = make([]queryParam, 0, len(params))
+	for _, param := range params {
+		if param.Raw == "" {
+			kept = append(kept, param)
+			continue
+		}
+		d.reportEvent(EventParamChecked)
+
+		rule := firstMatchingRule(rules, param.Key)
+		if rule == nil {
+			kept = append(kept, param)
+			continue
+		}
+		if ignored := firstMatchingRule(provider.IgnoredParameters, param.Key); ignored != nil {
+			d.addStep(trace, Step{Kind: STEP_IGNORED, Provider: provider.Name, Param: param.Key}, ignored)
+			kept = append(kept, param)
+			continue
+		}
+		d.reportEvent(EventParamRemoved)
+		d.addStep(trace, Step{Kind: STEP_REMOVED, Provider: provider.Name, Param: param.Key}, rule)
+		removed = true
+	}
+	return kept, removed
+}
+
+func matchesAnyRule(rules []*regexp2.Regexp, paramName string) bool {
+	return firstMatchingRule(rules, paramName) != nil
+}
+
+func firstMatchingRule(rules []*regexp2.Regexp, paramName string) *regexp2.Regexp {
+	for _, rule := range rules {
+		if match, _ := rule.MatchString(paramName); match {
+			return rule
+		}
+	}
+	return nil
+}
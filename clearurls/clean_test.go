@@ -0,0 +1,245 @@
+package clearurls
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyRules(t *testing.T) {
+	amazon, err := makeProvider("amazon", rawProvider{
+		UrlPatternStr:        `^https?:\/\/(?:[a-z0-9-]+\.)*?amazon\.com`,
+		RulesStr:             []string{"pf_rd_[a-z]*"},
+		RawRulesStr:          []string{`\/ref=[^\/?]*`},
+		ReferralMarketingStr: []string{"tag"},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+	tracker, err := makeProvider("tracker", rawProvider{
+		UrlPatternStr:    `^https?:\/\/track\.example\.com`,
+		ExceptionsStr:    []string{`^https?:\/\/track\.example\.com\/optout`},
+		CompleteProvider: true,
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		provider      Provider
+		url           string
+		want          string
+		stripReferral bool
+		wantBlocked   bool
+	}{
+		{"rawRules", amazon, "https://www.amazon.com/dp/B0000000/ref=sr_1_1?pf_rd_p=abc", "https://www.amazon.com/dp/B0000000", false, false},
+		{"referralKept", amazon, "https://www.amazon.com/dp/B0000000?tag=foo-20", "https://www.amazon.com/dp/B0000000?tag=foo-20", false, false},
+		{"referralStripped", amazon, "https://www.amazon.com/dp/B0000000?tag=foo-20", "https://www.amazon.com/dp/B0000000", true, false},
+		{"completeProvider", tracker, "https://track.example.com/c?id=1", "https://track.example.com/c?id=1", false, true},
+		{"completeProviderException", tracker, "https://track.example.com/optout", "https://track.example.com/optout", false, false},
+		{"notMatching", tracker, "https://example.com/c?id=1", "https://example.com/c?id=1", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &Data{StripReferralMarketing: tt.stripReferral}
+			got, _, gotBlocked := data.applyRules(tt.provider, tt.url, false, nil)
+			if got != tt.want {
+				t.Errorf("applyRules() = %v, want %v", got, tt.want)
+			}
+			if gotBlocked != tt.wantBlocked {
+				t.Errorf("applyRules() blocked = %v, want %v", gotBlocked, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestCleanUrlRedirect(t *testing.T) {
+	google, err := makeProvider("google", rawProvider{
+		UrlPatternStr:   `^https?:\/\/(?:[a-z0-9-]+\.)*?google(?:\.[a-z]{2,}){1,}`,
+		RulesStr:        []string{"ved", "usg"},
+		RedirectionsStr: []string{`^https?:\/\/(?:[a-z0-9-]+\.)*?google(?:\.[a-z]{2,}){1,}\/url\?.*?(?:url|q)=(https?[^&]+)`},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+	facebook, err := makeProvider("facebook", rawProvider{
+		UrlPatternStr:   `^https?:\/\/(?:[a-z0-9-]+\.)*?facebook\.com`,
+		RedirectionsStr: []string{`^https?:\/\/l[a-z]?\.facebook\.com\/l\.php\?.*?u=(https?%3A%2F%2F[^&]*)`},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+	opaque, err := makeProvider("opaque", rawProvider{
+		UrlPatternStr:   `^https?:\/\/out\.example\.com`,
+		RedirectionsStr: []string{`^https?:\/\/out\.example\.com\/go\/`},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+	global, err := makeProvider("globalRules", rawProvider{
+		UrlPatternStr: ".*",
+		RulesStr:      []string{"utm_source", "fbclid"},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+	data := &Data{
+		GlobalRules: global,
+		Providers: map[string]Provider{
+			"google":   google,
+			"facebook": facebook,
+			"opaque":   opaque,
+		},
+	}
+	data.buildIndex()
+
+	tests := []struct {
+		name         string
+		url          string
+		want         string
+		wantRedirect bool
+	}{
+		{"google", "https://www.google.com/url?sa=t&url=https%3A%2F%2Fexample.com%2Fpage%3Fid%3D1%26utm_source%3Dgoogle&ved=abc&usg=def", "https://example.com/page?id=1", false},
+		{"facebook", "https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2F%3Ffbclid%3Dxyz&h=AT0", "https://example.com/", false},
+		{"nested", "https://www.google.com/url?q=https%3A%2F%2Fl.facebook.com%2Fl.php%3Fu%3Dhttps%253A%252F%252Fexample.com%252Fa&usg=def", "https://example.com/a", false},
+		{"noCaptureGroup", "https://out.example.com/go/123", "https://out.example.com/go/123", true},
+		{"notRedirect", "https://www.google.com/search?q=test&ved=abc", "https://www.google.com/search?q=test", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := data.CleanUrl(tt.url)
+			got, gotRedirect := result.Processed, result.IsRedirect
+			if got != tt.want {
+				t.Errorf("CleanUrl() = %v, want %v", got, tt.want)
+			}
+			if gotRedirect != tt.wantRedirect {
+				t.Errorf("CleanUrl() redirect = %v, want %v", gotRedirect, tt.wantRedirect)
+			}
+		})
+	}
+}
+
+// cleanUrlTests are real world urls, cleaned by the local rules of loadLocalRules
+var cleanUrlTests = []struct {
+	name string
+	url  string
+	want string
+}{
+	{"x", "https://x.com/4009_0825900/status/1840979404572213471?t=iy49kBSlrMutQ0QwNW4YyA&s=19", "https://x.com/4009_0825900/status/1840979404572213471?s=19"},
+	{"youtuBe", "https://youtu.be/aVpJGGQHSqc?si=az72VbWhlionVl4c", "https://youtu.be/aVpJGGQHSqc"},
+	{"youtube", "https://www.youtube.com/watch?v=n-su1KVKlGk", "https://www.youtube.com/watch?v=n-su1KVKlGk"},
+	{"reddit", "https://www.reddit.com/r/MechanicalKeyboards/comments/156he48/attention_new_issue_with_gmk_keycaps_know_before/", "https://www.reddit.com/r/MechanicalKeyboards/comments/156he48/attention_new_issue_with_gmk_keycaps_know_before/"},
+	{"ettoday", "https://travel.ettoday.net/amp/amp_news.php7?news_id=2738515&ref=mw&from=google.com", "https://travel.ettoday.net/amp/amp_news.php7?news_id=2738515"},
+	{"chinaAirlines", "https://www.china-airlines.com/zh-tw/tpe_20240906_autumn?gad_source=1&gclid=Cj0KCQjwo8S3BhDeARIsAFRmkOPMStVGw370iMxB8F3mTp9CB2ZgPRWsc2B1I5rkczwm_fcGrYrkD5EaAmDHEALw_wcB", "https://www.china-airlines.com/zh-tw/tpe_20240906_autumn"},
+	{"tomtoc", "https://www.tomtoc.com.tw/t21s1d2?_gl=1*1j8iyju*_up*MQ..&gclid=EAIaIQobChMImJvOisbaiAMVYcRMAh3OZSGtEAAYASAAEgJcVPD_BwE", "https://www.tomtoc.com.tw/t21s1d2"},
+	{"cathaybk", "https://cathaybk.com.tw/cathaybk/personal/product/credit-card/cards/eva/?CUB_SRC=GOOGLE&CUB_CHL1=AD_WORD&CUB_CHL2=01&MA_TK=DB590&CUB_DT=20240101&Cub_ProjectCode=DBB4400001&gad_source=1&gclid=Cj0KCQjw9Km3BhDjARIsAGUb4nygrkAZpfoCJo3YVMkZsSfpMtF8I2aoAy22EAOp8REOOeSlSd5r5d0aAk6zEALw_wcB", "https://cathaybk.com.tw/cathaybk/personal/product/credit-card/cards/eva/?CUB_SRC=GOOGLE&CUB_CHL1=AD_WORD&CUB_CHL2=01&MA_TK=DB590&CUB_DT=20240101&Cub_ProjectCode=DBB4400001"},
+	{"momoshop", "https://m.momoshop.com.tw/goods.momo?i_code=10489628&osm=Ad07&utm_source=googleshop&utm_medium=googleshop-pmax-all-mb-feed&utm_content=bn&gclid=Cj0KCQjwwae1BhC_ARIsAK4Jfrw5xTuyBtdUOafMEZCD3bV0d6H77it_bp5Zi0UrzXjK69ztk3Z_hXgaAiFVEALw_wcB", "https://m.momoshop.com.tw/goods.momo?i_code=10489628"},
+}
+
+// loadLocalRules loads the bundled rules with the custom rules and aliases of the repository,
+// the ruleset the bot ends up with when ClearURLs can't be reached
+func loadLocalRules(tb testing.TB) *Data {
+	tb.Helper()
+	var sources []string
+	for _, file := range []string{CUSTOM_RULES_FILE, ALIAS_FILE} {
+		path, err := filepath.Abs(filepath.Join("..", file))
+		if err != nil {
+			tb.Fatalf("Abs() error = %v", err)
+		}
+		sources = append(sources, path)
+	}
+	chdirTemp(tb)
+	if err := os.WriteFile("bundled_rules.json", bundledRules, 0644); err != nil {
+		tb.Fatalf("WriteFile() error = %v", err)
+	}
+	data, err := LoadRules(append([]string{"bundled_rules.json"}, sources...))
+	if err != nil {
+		tb.Fatalf("LoadRules() error = %v", err)
+	}
+	return data
+}
+
+func TestCleanUrl(t *testing.T) {
+	data := loadLocalRules(t)
+	for _, tt := range cleanUrlTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := data.CleanUrl(tt.url).Processed; got != tt.want {
+				t.Errorf("CleanUrl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkCleanUrl(b *testing.B) {
+	indexed := loadLocalRules(b)
+
+	// Same rules, but every provider is checked against every url like before indexing
+	linear := *indexed
+	linear.domainIndex = nil
+	linear.generic = make([]int, len(linear.ordered))
+	for i := range linear.generic {
+		linear.generic[i] = i
+	}
+
+	for _, bench := range []struct {
+		name string
+		data *Data
+	}{
+		{"Indexed", indexed},
+		{"Linear", &linear},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, tt := range cleanUrlTests {
+					bench.data.CleanUrl(tt.url)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanUrlFragment(t *testing.T) {
+	spa, err := makeProvider("spa", rawProvider{
+		UrlPatternStr:    `^https?:\/\/(?:[a-z0-9-]+\.)*?spa\.example\.com`,
+		RulesStr:         []string{"^from$"},
+		FragmentRulesStr: []string{"^share_token$"},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+	global, err := makeProvider("globalRules", rawProvider{
+		UrlPatternStr: ".*",
+		RulesStr:      []string{"^utm_[a-z]+$", "^fbclid$"},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+	data := &Data{
+		GlobalRules: global,
+		Providers:   map[string]Provider{"spa": spa},
+	}
+	data.buildIndex()
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"hashQuery", "https://news.example.org/article/1#?utm_source=line&utm_medium=social", "https://news.example.org/article/1"},
+		{"hashBang", "https://shop.example.org/#!/item/42?fbclid=abc&color=red", "https://shop.example.org/#!/item/42?color=red"},
+		{"hashRouterAllRemoved", "https://shop.example.org/#/item/42?fbclid=abc", "https://shop.example.org/#/item/42"},
+		{"hashParams", "https://example.org/page#utm_source=x&id=3", "https://example.org/page#id=3"},
+		{"queryAndFragment", "https://example.org/?utm_source=x&p=1#/list?utm_campaign=y", "https://example.org/?p=1#/list"},
+		{"plainAnchor", "https://example.org/docs#installation", "https://example.org/docs#installation"},
+		{"textFragment", "https://example.org/docs#:~:text=hello", "https://example.org/docs#:~:text=hello"},
+		{"fragmentRuleOnlyInFragment", "https://spa.example.com/?share_token=1#/post?share_token=2&from=3", "https://spa.example.com/?share_token=1#/post"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := data.CleanUrl(tt.message).Processed; got != tt.want {
+				t.Errorf("Got= %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Package clearurls removes tracking from urls with ClearURLs style rules.
+//
+// Rules are loaded from any number of sources with LoadRules into an immutable Data,
+// a Cleaner keeps the active Data and swaps in a fresh one on every Reload.
+package clearurls
+
+import (
+	"sync/atomic"
+)
+
+// Event is something worth counting that happened while cleaning a url
+type Event int
+
+const (
+	EventUrlCleaned   Event = iota // A url was changed, reported once per url
+	EventRedirect                  // A redirect was unwrapped or flagged
+	EventBlocked                   // A url matched a completeProvider
+	EventParamChecked              // A query or fragment param was checked against the rules
+	EventParamRemoved              // A query or fragment param was removed
+)
+
+// Reporter receives every Event of Data.CleanUrlTraced. It's called from whatever goroutine is cleaning,
+// so it must be safe for concurrent use.
+type Reporter func(event Event)
+
+// Cleaner owns the active ruleset and swaps in a rebuilt one on every Reload.
+// Callers cleaning several urls together should call Data once and keep using that snapshot.
+type Cleaner struct {
+	load                   func() (*Data, error)
+	current                atomic.Pointer[Data]
+	StripReferralMarketing bool
+	// Offline makes every Reload from now on take remote rule sources from their cache (or the bundled rules),
+	// without touching the network
+	Offline bool
+}
+
+// NewCleaner makes a Cleaner loading the rule sources with LoadRules, nothing is loaded before the first Reload
+func NewCleaner(sources []string) *Cleaner {
+	c := &Cleaner{}
+	c.load = func() (*Data, error) {
+		return loadRules(sources, rawReader(readOptions{offline: c.Offline}))
+	}
+	return c
+}
+
+// Data returns the active ruleset, nil before the first successful Reload
+func (c *Cleaner) Data() *Data {
+	return c.current.Load()
+}
+
+// Reload rebuilds the ruleset from the rule sources,
+// the previous ruleset stays active if anything fails to load or compile
+func (c *Cleaner) Reload() error {
+	data, err := c.load()
+	if err != nil {
+		return err
+	}
+	data.StripReferralMarketing = c.StripReferralMarketing
+	c.current.Store(data)
+	return nil
+}
+
+// CleanUrl cleans the url with the active ruleset, see Data.CleanUrl. The url is left as it is before the first successful Reload.
+func (c *Cleaner) CleanUrl(url string) Result {
+	data := c.Data()
+	if data == nil {
+		return Result{Url: url, Processed: url}
+	}
+	return data.CleanUrl(url)
+}
+
+// IsUrlSafe checks the url with the active ruleset, see Data.IsUrlSafe. No url is safe before the first successful Reload.
+func (c *Cleaner) IsUrlSafe(url string) bool {
+	data := c.Data()
+	if data == nil {
+		return false
+	}
+	return data.IsUrlSafe(url)
+}
+
+func (d *Data) reportEvent(event Event) {
+	if d.report != nil {
+		d.report(event)
+	}
+}
+
+// MatchingProviders lists the providers whose urlPattern or aliases match the url, in the order they are tried,
+// globalRules last
+func (d *Data) MatchingProviders(url string) []string {
+	var names []string
+	for _, provider := range d.candidates(url) {
+		if matchesProvider(provider, url) {
+			names = append(names, provider.Name)
+		}
+	}
+	if matchesProvider(d.GlobalRules, url) {
+		names = append(names, d.GlobalRules.Name)
+	}
+	return names
+}
@@ -0,0 +1,181 @@
+package clearurls
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCleanerReload(t *testing.T) {
+	first := &Data{Providers: map[string]Provider{"first": {}}}
+	second := &Data{Providers: map[string]Provider{"second": {}}}
+	results := []struct {
+		data *Data
+		err  error
+	}{
+		{first, nil},
+		{nil, errors.New("failed to make provider broken")},
+		{second, nil},
+	}
+	call := 0
+	m := &Cleaner{
+		load: func() (*Data, error) {
+			r := results[call]
+			call++
+			return r.data, r.err
+		},
+		StripReferralMarketing: true,
+	}
+
+	if m.Data() != nil {
+		t.Fatalf("Data() before Reload = %v, want nil", m.Data())
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if m.Data() != first || !first.StripReferralMarketing {
+		t.Fatalf("Data() = %v, want first ruleset with referral stripping", m.Data())
+	}
+	if err := m.Reload(); err == nil {
+		t.Fatalf("Reload() error = nil, want error")
+	}
+	if m.Data() != first {
+		t.Fatalf("Data() after failed Reload = %v, want first ruleset kept", m.Data())
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if m.Data() != second {
+		t.Fatalf("Data() = %v, want second ruleset", m.Data())
+	}
+}
+
+func TestCleanerConcurrentSwap(t *testing.T) {
+	m := &Cleaner{
+		load: func() (*Data, error) {
+			return &Data{Providers: map[string]Provider{}}, nil
+		},
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Reload()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if m.Data() == nil {
+					t.Error("Data() = nil during swap")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCleanerOffline(t *testing.T) {
+	chdirTemp(t)
+	requests := 0
+	online := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(testOnlineRules))
+	}))
+	defer online.Close()
+
+	c := NewCleaner([]string{online.URL})
+	c.Offline = true
+	if err := c.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if requests != 0 || c.Data().Sources[0].Status != RULES_SOURCE_BUNDLED {
+		t.Errorf("offline Reload() made %d requests and loaded %v, want none and the bundled rules", requests, c.Data().Sources)
+	}
+
+	c.Offline = false
+	if err := c.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if requests != 1 || c.Data().Sources[0].Status != RULES_SOURCE_ONLINE {
+		t.Errorf("Reload() made %d requests and loaded %v, want the online rules", requests, c.Data().Sources)
+	}
+}
+
+func TestCleanerNotLoaded(t *testing.T) {
+	chdirTemp(t)
+	if err := os.WriteFile("rules.json", []byte("{"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	c := NewCleaner([]string{"rules.json"})
+	if err := c.Reload(); err == nil {
+		t.Fatalf("Reload() of broken rules succeeded")
+	}
+	url := "https://example.com/?utm_source=x"
+	if got := c.CleanUrl(url); got != (Result{Url: url, Processed: url}) {
+		t.Errorf("CleanUrl() before loading = %+v, want the url as it is", got)
+	}
+	if c.IsUrlSafe(url) {
+		t.Errorf("IsUrlSafe() before loading = true, want false")
+	}
+}
+
+func TestReporter(t *testing.T) {
+	chdirTemp(t)
+	rules := `{"providers":{
+		"globalRules":{"urlPattern":".*","rules":["utm_source"]},
+		"shop":{"urlPattern":"^https?:\\/\\/shop\\.com","rules":["ref"],"redirections":["^https?:\\/\\/shop\\.com\\/out\\?to=(https?[^&]+)"]},
+		"tracker":{"urlPattern":"^https?:\\/\\/track\\.com","completeProvider":true}}}`
+	if err := os.WriteFile("rules.json", []byte(rules), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var got []Event
+	report := func(event Event) {
+		got = append(got, event)
+	}
+	c := NewCleaner([]string{"rules.json"})
+	if err := c.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want []Event
+	}{
+		{"cleaned", "https://shop.com/?ref=1&id=2", []Event{
+			EventParamChecked, EventParamRemoved, // ref by shop
+			EventParamChecked, // id by shop
+			EventParamChecked, // id by globalRules
+			EventUrlCleaned,
+		}},
+		{"unwrapped", "https://shop.com/out?to=https%3A%2F%2Fexample.com%2F", []Event{EventRedirect, EventUrlCleaned}},
+		{"blocked", "https://track.com/c", []Event{EventBlocked}},
+		{"untouched", "https://example.com/", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			c.CleanUrl(tt.url)
+			if got != nil {
+				t.Errorf("CleanUrl() reported %v, want nothing", got)
+			}
+			c.Data().CleanUrlTraced(tt.url, report)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CleanUrlTraced() reported %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
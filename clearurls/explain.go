@@ -0,0 +1,126 @@
+package clearurls
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+)
+
+const (
+	STEP_UNWRAPPED = "unwrapped" // A redirection rule captured the destination, which is cleaned instead
+	STEP_MATCHED   = "matched"   // The provider's urlPattern or one of its aliases matched
+	STEP_EXCEPTION = "exception" // An exception matched, the provider leaves the url alone
+	STEP_REDIRECT  = "redirect"  // A redirection rule matched but the destination couldn't be unwrapped
+	STEP_BLOCKED   = "blocked"   // The provider is a completeProvider, the whole url is a tracker
+	STEP_RAW_RULE  = "rawRule"   // A raw rule changed the url
+	STEP_REMOVED   = "removed"   // A rule matched the param, it was removed
+	STEP_IGNORED   = "ignored"   // A rule matched the param but an ignoredParameter kept it
+)
+
+// Step is one decision taken while cleaning a url
+type Step struct {
+	Kind     string `json:"kind"` // One of the STEP_* values
+	Provider string `json:"provider"`
+	Url      string `json:"url,omitempty"`    // The url the step applied to, empty for param steps
+	Target   string `json:"target,omitempty"` // Where an unwrapped redirect leads
+	Param    string `json:"param,omitempty"`
+	Alias    string `json:"alias,omitempty"`   // The alias key the provider matched through
+	Pattern  string `json:"pattern,omitempty"` // The urlPattern, alias or rule responsible
+	Source   string `json:"source,omitempty"`  // The rule source the pattern came from
+}
+
+func (s Step) String() string {
+	var what string
+	switch s.Kind {
+	case STEP_UNWRAPPED:
+		what = fmt.Sprintf("unwrapped redirect to %s", s.Target)
+	case STEP_MATCHED:
+		what = "matched " + s.Url
+		if s.Alias != "" {
+			what += " through alias " + s.Alias
+		}
+	case STEP_EXCEPTION:
+		what = "exception, nothing removed"
+	case STEP_REDIRECT:
+		what = "flagged as redirect"
+	case STEP_BLOCKED:
+		what = "blocked, the whole url is a tracker"
+	case STEP_RAW_RULE:
+		what = "raw rule applied"
+	case STEP_REMOVED:
+		what = "removed " + s.Param
+	case STEP_IGNORED:
+		what = fmt.Sprintf("kept %s, ignored parameter", s.Param)
+	default:
+		what = s.Kind
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(s.Provider)
+	sb.WriteString(": ")
+	sb.WriteString(what)
+	if s.Pattern != "" {
+		sb.WriteString(" by ")
+		sb.WriteString(s.Pattern)
+	}
+	if s.Source != "" {
+		sb.WriteString(" (")
+		sb.WriteString(s.Source)
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
+
+// Trace lists the steps taken while cleaning a url, in order
+type Trace struct {
+	Steps []Step
+}
+
+// Explain cleans url like CleanUrl and tells how it was done. Nothing is reported.
+func (d *Data) Explain(url string) (Result, Trace) {
+	return d.CleanUrlTraced(url, nil)
+}
+
+// CleanUrlTraced is Explain sending the events of cleaning to report, nil to report nothing
+func (d *Data) CleanUrlTraced(url string, report Reporter) (Result, Trace) {
+	reporting := *d
+	reporting.report = report
+	var trace Trace
+	result := reporting.cleanAndReport(url, &trace)
+	return result, trace
+}
+
+// addStep appends step to trace unless it is nil, pattern fills in the pattern, its source and its alias key
+func (d *Data) addStep(trace *Trace, step Step, pattern *regexp2.Regexp) {
+	if trace == nil {
+		return
+	}
+	if pattern != nil {
+		step.Pattern = pattern.String()
+		step.Source = d.patternSources[pattern]
+		if step.Kind == STEP_MATCHED {
+			step.Alias = d.aliasKeys[pattern]
+		}
+	}
+	trace.Steps = append(trace.Steps, step)
+}
+
+// recordSources remembers location as the source of every pattern of provider
+func (d *Data) recordSources(provider Provider, location string) {
+	d.patternSources[provider.UrlPattern] = location
+	for _, patterns := range [][]*regexp2.Regexp{
+		provider.Rules,
+		provider.Exceptions,
+		provider.IgnoredParameters,
+		provider.Redirections,
+		provider.SafeParameters,
+		provider.RawRules,
+		provider.ReferralMarketing,
+		provider.FragmentRules,
+	} {
+		for _, pattern := range patterns {
+			d.patternSources[pattern] = location
+		}
+	}
+}
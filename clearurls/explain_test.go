@@ -0,0 +1,105 @@
+package clearurls
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	const base = `{"providers":{
+		"globalRules":{"urlPattern":".*","rules":["utm_source"]},
+		"shop":{"urlPattern":"^https?:\\/\\/shop\\.com","rules":["ref"],"ignoredParameters":["ref_id"],
+			"rawRules":["\\/track\\/[0-9]+"],"exceptions":["^https?:\\/\\/shop\\.com\\/login"]},
+		"out":{"urlPattern":"^https?:\\/\\/out\\.com","redirections":["^https?:\\/\\/out\\.com\\/\\?u=([^&]+)"]},
+		"ads":{"urlPattern":"^https?:\\/\\/ads\\.com","completeProvider":true}}}`
+	const custom = `{"providers":{"shop":{"rules":["aff"]}},
+	"aliases":{"shop-mirror":{"urlPattern":"^https?:\\/\\/mirror\\.com","targetRuleName":"shop"}}}`
+	chdirTemp(t)
+	if err := os.WriteFile("base.json", []byte(base), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile("custom.json", []byte(custom), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	d, err := LoadRules([]string{"base.json", "custom.json"})
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		url       string
+		want      string
+		wantSteps []Step
+	}{
+		{
+			name: "removed and ignored params with their sources",
+			url:  "https://shop.com/item?ref=1&ref_id=2&aff=3&utm_source=a",
+			want: "https://shop.com/item?ref_id=2",
+			wantSteps: []Step{
+				{Kind: STEP_MATCHED, Provider: "shop", Url: "https://shop.com/item?ref=1&ref_id=2&aff=3&utm_source=a", Pattern: `^https?:\/\/shop\.com`, Source: "base.json"},
+				{Kind: STEP_REMOVED, Provider: "shop", Param: "ref", Pattern: "ref", Source: "base.json"},
+				{Kind: STEP_IGNORED, Provider: "shop", Param: "ref_id", Pattern: "ref_id", Source: "base.json"},
+				{Kind: STEP_REMOVED, Provider: "shop", Param: "aff", Pattern: "aff", Source: "custom.json"},
+				{Kind: STEP_MATCHED, Provider: "globalRules", Url: "https://shop.com/item?ref_id=2&utm_source=a", Pattern: ".*", Source: "base.json"},
+				{Kind: STEP_REMOVED, Provider: "globalRules", Param: "utm_source", Pattern: "utm_source", Source: "base.json"},
+			},
+		},
+		{
+			name: "alias, raw rule",
+			url:  "https://mirror.com/track/42?ref=1",
+			want: "https://mirror.com",
+			wantSteps: []Step{
+				{Kind: STEP_MATCHED, Provider: "shop", Url: "https://mirror.com/track/42?ref=1", Alias: "shop-mirror", Pattern: `^https?:\/\/mirror\.com`, Source: "custom.json"},
+				{Kind: STEP_RAW_RULE, Provider: "shop", Url: "https://mirror.com/track/42?ref=1", Pattern: `\/track\/[0-9]+`, Source: "base.json"},
+				{Kind: STEP_REMOVED, Provider: "shop", Param: "ref", Pattern: "ref", Source: "base.json"},
+				{Kind: STEP_MATCHED, Provider: "globalRules", Url: "https://mirror.com", Pattern: ".*", Source: "base.json"},
+			},
+		},
+		{
+			name: "exception",
+			url:  "https://shop.com/login?ref=1",
+			want: "https://shop.com/login?ref=1",
+			wantSteps: []Step{
+				{Kind: STEP_MATCHED, Provider: "shop", Url: "https://shop.com/login?ref=1", Pattern: `^https?:\/\/shop\.com`, Source: "base.json"},
+				{Kind: STEP_EXCEPTION, Provider: "shop", Url: "https://shop.com/login?ref=1", Pattern: `^https?:\/\/shop\.com\/login`, Source: "base.json"},
+				{Kind: STEP_MATCHED, Provider: "globalRules", Url: "https://shop.com/login?ref=1", Pattern: ".*", Source: "base.json"},
+			},
+		},
+		{
+			name: "unwrapped redirect",
+			url:  "https://out.com/?u=https%3A%2F%2Fads.com%2Fx",
+			want: "https://ads.com/x",
+			wantSteps: []Step{
+				{Kind: STEP_UNWRAPPED, Provider: "out", Url: "https://out.com/?u=https%3A%2F%2Fads.com%2Fx", Target: "https://ads.com/x", Pattern: `^https?:\/\/out\.com\/\?u=([^&]+)`, Source: "base.json"},
+				{Kind: STEP_MATCHED, Provider: "ads", Url: "https://ads.com/x", Pattern: `^https?:\/\/ads\.com`, Source: "base.json"},
+				{Kind: STEP_BLOCKED, Provider: "ads", Url: "https://ads.com/x"},
+				{Kind: STEP_MATCHED, Provider: "globalRules", Url: "https://ads.com/x", Pattern: ".*", Source: "base.json"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, trace := d.Explain(tt.url)
+			if result.Processed != tt.want {
+				t.Errorf("Explain() processed = %v, want %v", result.Processed, tt.want)
+			}
+			if result != d.CleanUrl(tt.url) {
+				t.Errorf("Explain() = %+v, CleanUrl() = %+v", result, d.CleanUrl(tt.url))
+			}
+			if !reflect.DeepEqual(trace.Steps, tt.wantSteps) {
+				t.Errorf("Explain() steps:\n%v\nwant:\n%v", trace.Steps, tt.wantSteps)
+			}
+		})
+	}
+
+	var reported int
+	result, trace := d.CleanUrlTraced("https://shop.com/item?ref=1", func(Event) { reported++ })
+	wantResult, wantTrace := d.Explain("https://shop.com/item?ref=1")
+	if result != wantResult || !reflect.DeepEqual(trace, wantTrace) {
+		t.Errorf("CleanUrlTraced() = %+v, %v, want %+v, %v", result, trace, wantResult, wantTrace)
+	}
+	if reported == 0 {
+		t.Errorf("CleanUrlTraced() reported no events")
+	}
+}
@@ -0,0 +1,102 @@
+package clearurls
+
+import (
+	"net/url"
+	"strings"
+)
+
+// queryParam is a single query parameter, Raw keeps the original text so untouched params round-trip byte-for-byte
+type queryParam struct {
+	Raw   string
+	Key   string
+	Value string
+}
+
+// parsedUrl splits a url into everything before the query, the ordered query params and the fragment.
+// Fragments carrying params (#?utm_source=..., #!/page?fbclid=..., #a=1&b=2) have them split into FragmentParams.
+type parsedUrl struct {
+	Base             string
+	HasQuery         bool
+	Params           []queryParam
+	Fragment         string // Including the leading '#', up to and including the '?' before FragmentParams
+	HasFragmentQuery bool
+	FragmentParams   []queryParam
+}
+
+func parseUrl(src string) parsedUrl {
+	parsed := parsedUrl{}
+	if i := strings.IndexByte(src, '#'); i >= 0 {
+		parsed.Fragment, parsed.FragmentParams, parsed.HasFragmentQuery = parseFragment(src[i:])
+		src = src[:i]
+	}
+	if i := strings.IndexByte(src, '?'); i >= 0 {
+		parsed.HasQuery = true
+		parsed.Params = parseQuery(src[i+1:])
+		src = src[:i]
+	}
+	parsed.Base = src
+	return parsed
+}
+
+// parseFragment finds params in hash router style fragments, plain anchors like #section are left alone
+func parseFragment(fragment string) (prefix string, params []queryParam, ok bool) {
+	if i := strings.IndexByte(fragment, '?'); i >= 0 {
+		return fragment[:i+1], parseQuery(fragment[i+1:]), true
+	}
+	if strings.Contains(fragment, "=") {
+		return "#", parseQuery(fragment[1:]), true
+	}
+	return fragment, nil, false
+}
+
+// parseQuery splits the query on '&', empty pieces are kept so "a=1&&b=2" and a lone "?" survive a rebuild
+func parseQuery(query string) []queryParam {
+	pieces := strings.Split(query, "&")
+	params := make([]queryParam, 0, len(pieces))
+	for _, piece := range pieces {
+		key, value, _ := strings.Cut(piece, "=")
+		params = append(params, queryParam{
+			Raw:   piece,
+			Key:   decodeQueryComponent(key),
+			Value: decodeQueryComponent(value),
+		})
+	}
+	return params
+}
+
+// decodeQueryComponent falls back to the raw text for malformed escapes instead of dropping the param
+func decodeQueryComponent(s string) string {
+	decoded, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+func encodeQuery(params []queryParam) string {
+	sb := strings.Builder{}
+	for i, param := range params {
+		if i > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(param.Raw)
+	}
+	return sb.String()
+}
+
+// String rebuilds the url, the '?' (and a fragment left empty) is dropped once every param is removed
+func (p parsedUrl) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(p.Base)
+	if p.HasQuery && len(p.Params) > 0 {
+		sb.WriteByte('?')
+		sb.WriteString(encodeQuery(p.Params))
+	}
+	if !p.HasFragmentQuery || len(p.FragmentParams) > 0 {
+		sb.WriteString(p.Fragment)
+		sb.WriteString(encodeQuery(p.FragmentParams))
+	} else if fragment := strings.TrimSuffix(p.Fragment, "?"); fragment != "#" {
+		sb.WriteString(fragment)
+	}
+	return sb.String()
+}
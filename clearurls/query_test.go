@@ -0,0 +1,79 @@
+package clearurls
+
+import (
+	"testing"
+)
+
+func TestParseUrlRoundTrip(t *testing.T) {
+	tests := []string{
+		"https://example.com",
+		"https://example.com/?",
+		"https://example.com/?a=1&&b=2&",
+		"https://example.com/search?q=a%20b+c|d:e&lang=zh-TW",
+		"https://tw.news.yahoo.com/美國廠員工控-041403730.html?t=你好&x=%E4%BD%A0",
+		"https://example.com/?flag&empty=&eq=a=b",
+		"https://example.com/?a=1#section?utm_source=x",
+		"https://example.com/?bad=%zz",
+		"https://example.com/#!/page?fbclid=1&&x=%20",
+		"https://example.com/#utm_source=a&b",
+		"https://example.com/#?",
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			if got := parseUrl(tt).String(); got != tt {
+				t.Errorf("parseUrl().String() = %v, want %v", got, tt)
+			}
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	got := parseQuery("utm%5Fsource=a%20b&q=1+2&flag&bad=%zz")
+	want := []queryParam{
+		{Raw: "utm%5Fsource=a%20b", Key: "utm_source", Value: "a b"},
+		{Raw: "q=1+2", Key: "q", Value: "1 2"},
+		{Raw: "flag", Key: "flag", Value: ""},
+		{Raw: "bad=%zz", Key: "bad", Value: "%zz"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseQuery() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseQuery()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestApplyRulesQuery(t *testing.T) {
+	provider, err := makeProvider("example", rawProvider{
+		UrlPatternStr:        `^https?:\/\/(?:[a-z0-9-]+\.)*?example\.com`,
+		RulesStr:             []string{"^utm_[a-z]+$", "^ref$"},
+		IgnoredParametersStr: []string{"^utm_keep$"},
+	})
+	if err != nil {
+		t.Fatalf("makeProvider() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"encodedName", "https://example.com/?utm%5Fsource=x&id=1", "https://example.com/?id=1"},
+		{"specialValues", "https://example.com/?q=a%20b+c|d:é&utm_medium=x%20y", "https://example.com/?q=a%20b+c|d:é"},
+		{"onlyParamRemoved", "https://example.com/page?ref=1", "https://example.com/page"},
+		{"middleParam", "https://example.com/?a=ref=1&ref=1&b=2", "https://example.com/?a=ref=1&b=2"},
+		{"sameTextInPath", "https://example.com/&ref=1/?ref=1", "https://example.com/&ref=1/"},
+		{"ignored", "https://example.com/?utm_keep=1&utm_source=2", "https://example.com/?utm_keep=1"},
+		{"fragmentKept", "https://example.com/?ref=1#top", "https://example.com/#top"},
+		{"untouched", "https://example.com/?a=%E4%BD%A0&&b", "https://example.com/?a=%E4%BD%A0&&b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, _ := (&Data{}).applyRules(provider, tt.url, false, nil); got != tt.want {
+				t.Errorf("applyRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
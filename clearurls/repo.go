@@ -0,0 +1,1055 @@
+package clearurls
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+// RULES_URL is where ClearURLs publishes its rules
+const RULES_URL = "https://rules2.clearurls.xyz/data.minify.json"
+
+// Provider represents a single provider from the ClearURLs data
+type Provider struct {
+	Name              string            `json:"-"`
+	Priority          int               `json:"-"`
+	UrlPattern        *regexp2.Regexp   `json:"-"`
+	Rules             []*regexp2.Regexp `json:"-"`
+	Exceptions        []*regexp2.Regexp `json:"-"`
+	IgnoredParameters []*regexp2.Regexp `json:"-"`
+	Redirections      []*regexp2.Regexp `json:"-"`
+	Aliases           []*regexp2.Regexp `json:"-"`
+	SafeParameters    []*regexp2.Regexp `json:"-"`
+	RawRules          []*regexp2.Regexp `json:"-"`
+	ReferralMarketing []*regexp2.Regexp `json:"-"`
+	FragmentRules     []*regexp2.Regexp `json:"-"`
+	CompleteProvider  bool              `json:"-"`
+}
+
+// rawProvider is used for intermediate JSON unmarshalling to keep the string values temporarily
+type rawProvider struct {
+	UrlPatternStr        string   `json:"urlPattern"`
+	RulesStr             []string `json:"rules"`
+	ExceptionsStr        []string `json:"exceptions"`
+	IgnoredParametersStr []string `json:"ignoredParameters"`
+	RedirectionsStr      []string `json:"redirections"`
+	SafeParametersStr    []string `json:"safeParameters"`
+	RawRulesStr          []string `json:"rawRules"`
+	ReferralMarketingStr []string `json:"referralMarketing"`
+	FragmentRulesStr     []string `json:"fragmentRules"`
+	CompleteProvider     bool     `json:"completeProvider"`
+
+	// Override directives, only meaningful for providers already loaded from an earlier rule source
+
+	// Disabled drops the provider, everything else in this entry is ignored
+	Disabled bool `json:"disabled"`
+	// Replace makes this entry replace the provider, urlPattern included, instead of extending it
+	Replace bool `json:"replace"`
+	// Remove lists patterns to take out of the provider, written exactly like they were added.
+	// completeProvider set here turns blocking off.
+	Remove *rawProvider `json:"remove"`
+}
+
+// Data represents the full JSON structure with all providers
+type Data struct {
+	GlobalRules Provider            `json:"-"`
+	Providers   map[string]Provider `json:"-"`
+	// Aliases maps every alias key to the providers (globalRules included) its urlPattern was added to
+	Aliases map[string][]string `json:"-"`
+	// Sources lists every rule source that made it into this ruleset, in load order
+	Sources  []LoadedRuleSource `json:"-"`
+	LoadedAt time.Time          `json:"-"`
+	// StripReferralMarketing makes referralMarketing params be removed like normal rules, off by default like ClearURLs
+	StripReferralMarketing bool `json:"-"`
+	// report receives the events of cleaning, only set on the copies made by CleanUrlTraced
+	report Reporter
+
+	// ordered holds every provider sorted by priority, see buildIndex
+	ordered []Provider
+	// domainIndex maps a host label to positions in ordered of providers requiring that label
+	domainIndex map[string][]int
+	// generic holds positions in ordered of providers which can't be indexed and are checked for every url
+	generic []int
+
+	// patternSources maps every compiled pattern to the rule source it came from, see Explain
+	patternSources map[*regexp2.Regexp]string
+	// aliasKeys maps the compiled urlPattern of every alias to its key
+	aliasKeys map[*regexp2.Regexp]string
+}
+
+const ONLINE_RULES_FILE = "clear_urls_rules.json"
+const RULES_CACHE_MAX_AGE = time.Hour * 6
+const CUSTOM_RULES_FILE = "custom_rules.json"
+const ALIAS_FILE = "aliases.json"
+
+// rawAlias is used for intermediate JSON unmarshalling to keep the string values temporarily
+type rawAlias struct {
+	UrlPatternStr   string   `json:"urlPattern"`
+	TargetRuleName  string   `json:"targetRuleName"`
+	TargetRuleNames []string `json:"targetRuleNames"`
+
+	location string // The rule source the alias came from
+}
+
+func (a rawAlias) targets() []string {
+	if a.TargetRuleName == "" {
+		return a.TargetRuleNames
+	}
+	return append([]string{a.TargetRuleName}, a.TargetRuleNames...)
+}
+
+const (
+	RULES_SOURCE_ONLINE  = "online"
+	RULES_SOURCE_CACHE   = "cache"
+	RULES_SOURCE_STALE   = "stale cache"
+	RULES_SOURCE_BUNDLED = "bundled"
+	RULES_SOURCE_FILE    = "file"
+)
+
+// LoadedRuleSource records where part of a ruleset came from
+type LoadedRuleSource struct {
+	Location string
+	Status   string // One of the RULES_SOURCE_* values
+	Sha256   string // Of the text the rules were decoded from, the rules have no version of their own
+}
+
+// rulesFile is the format of every rule source. ClearURLs data only has providers,
+// aliases can live in the same file or in a file of their own like aliases.json
+type rulesFile struct {
+	Providers map[string]rawProvider `json:"providers"`
+	Aliases   map[string]rawAlias    `json:"aliases"`
+}
+
+// bundledRules is a small last-known-good subset of the ClearURLs rules, only used when
+// the rules can't be downloaded and there is no cache to fall back on
+//
+//go:embed bundled_rules.json
+var bundledRules []byte
+
+// readOptions tells how remote rule sources are read
+type readOptions struct {
+	offline  bool // Only from the cache or the bundled rules, without touching the network
+	readOnly bool // Nothing is written to the cache
+}
+
+// loadOnlineRules returns the rules at url from the first usable source: the fresh cache, the network,
+// the stale cache and at last, for the base ClearURLs rules only, the bundled copy.
+// ok is false if none of them is available.
+func loadOnlineRules(url string, cacheFile string, base bool, opts readOptions) (raw string, source string, ok bool) {
+	cached, modTime, meta, cacheErr := readRulesCache(cacheFile)
+	if cacheErr == nil && time.Since(modTime) <= RULES_CACHE_MAX_AGE {
+		return cached, RULES_SOURCE_CACHE, true
+	}
+	if cacheErr != nil && !os.IsNotExist(cacheErr) {
+		log.Printf("Ignoring file cache of %s: %v", url, cacheErr)
+	}
+
+	if cacheErr != nil {
+		meta = rulesCacheMeta{} // Nothing to revalidate
+	}
+	var fetched string
+	var newMeta rulesCacheMeta
+	var notModified bool
+	err := errOffline
+	if !opts.offline {
+		fetched, newMeta, notModified, err = fetchRules(url, meta)
+	}
+	if err == nil && notModified {
+		if !opts.readOnly {
+			now := time.Now()
+			err = os.Chtimes(cacheFile, now, now)
+			if err != nil {
+				log.Printf("Failed to touch file cache of %s: %v", url, err)
+			}
+		}
+		log.Printf("Rules at %s not modified.", url)
+		return cached, RULES_SOURCE_CACHE, true
+	}
+	if err == nil {
+		if opts.readOnly {
+			return fetched, RULES_SOURCE_ONLINE, true
+		}
+		err = writeRulesCache(cacheFile, fetched, newMeta)
+		if err != nil {
+			log.Printf("Failed to write file cache of %s: %v", url, err)
+		} else {
+			log.Printf("Updated file cache of %s.", url)
+		}
+		return fetched, RULES_SOURCE_ONLINE, true
+	}
+	log.Printf("Failed to fetch rules at %s: %v", url, err)
+
+	if cacheErr == nil {
+		log.Printf("Using stale file cache of %s from %v.", url, modTime.Format(time.RFC3339))
+		return cached, RULES_SOURCE_STALE, true
+	}
+	if !base {
+		return "", "", false
+	}
+	log.Printf("No ClearUrls file cache, using bundled rules.")
+	return string(bundledRules), RULES_SOURCE_BUNDLED, true
+}
+
+var errOffline = errors.New("offline mode")
+
+// rulesCacheFile is where the rules at url are cached, the base rules keep the historical ONLINE_RULES_FILE
+func rulesCacheFile(url string, base bool) string {
+	if base {
+		return ONLINE_RULES_FILE
+	}
+	return fmt.Sprintf("clear_urls_rules_%s.json", sha256Hex([]byte(url))[:12])
+}
+
+func rulesMetaFile(cacheFile string) string {
+	return strings.TrimSuffix(cacheFile, ".json") + ".meta.json"
+}
+
+// rulesCacheMeta is stored next to the cache for conditional requests and to detect a damaged cache
+type rulesCacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Sha256       string `json:"sha256,omitempty"`
+}
+
+var rulesHttpClient = &http.Client{Timeout: time.Second * 30}
+
+func readRulesCache(cacheFile string) (raw string, modTime time.Time, meta rulesCacheMeta, err error) {
+	f, err := os.Open(cacheFile)
+	if err != nil {
+		return "", modTime, meta, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", modTime, meta, fmt.Errorf("stat: %w", err)
+	}
+	rawBytes, err := io.ReadAll(f)
+	if err != nil {
+		return "", modTime, meta, fmt.Errorf("readAll: %w", err)
+	}
+
+	metaBytes, err := os.ReadFile(rulesMetaFile(cacheFile))
+	if err == nil {
+		err = json.Unmarshal(metaBytes, &meta)
+		if err != nil {
+			return "", modTime, rulesCacheMeta{}, fmt.Errorf("unmarshal meta: %w", err)
+		}
+	}
+	if meta.Sha256 != "" && !strings.EqualFold(sha256Hex(rawBytes), meta.Sha256) {
+		return "", modTime, meta, fmt.Errorf("cache does not match its sha256 %s", meta.Sha256)
+	}
+	return string(rawBytes), fi.ModTime(), meta, nil
+}
+
+// writeRulesCache writes the meta before the cache itself. The meta holds the sha256 of the cache,
+// so if the cache fails to follow readRulesCache sees a mismatch and the cache is a miss.
+func writeRulesCache(cacheFile string, raw string, meta rulesCacheMeta) error {
+	meta.Sha256 = sha256Hex([]byte(raw))
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	err = WriteFileAtomic(rulesMetaFile(cacheFile), metaBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(cacheFile, []byte(raw), 0644)
+}
+
+// WriteFileAtomic writes to a temporary file next to name and renames it over name,
+// so readers never see a half written file
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("createTemp: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return fmt.Errorf("chmod: %w", err)
+	}
+	err = os.Rename(tmp.Name(), name)
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// rulesHashUrl returns where ClearURLs publishes the SHA-256 of the rules file, "" if unknown
+func rulesHashUrl(url string) string {
+	if !strings.HasSuffix(url, "/data.minify.json") {
+		return ""
+	}
+	return strings.TrimSuffix(url, "data.minify.json") + "rules.minify.hash"
+}
+
+// fetchRules downloads the rules, revalidating against meta when it has an ETag or Last-Modified,
+// and verifies the body against the published hash when there is one
+func fetchRules(url string, meta rulesCacheMeta) (raw string, newMeta rulesCacheMeta, notModified bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", newMeta, false, fmt.Errorf("newRequest: %w", err)
+	}
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+
+	resp, err := rulesHttpClient.Do(req)
+	if err != nil {
+		return "", newMeta, false, fmt.Errorf("get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && (meta.ETag != "" || meta.LastModified != "") {
+		return "", meta, true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", newMeta, false, fmt.Errorf("get: unexpected status %s", resp.Status)
+	}
+
+	rawBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", newMeta, false, fmt.Errorf("readAll: %w", err)
+	}
+
+	newMeta = rulesCacheMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Sha256:       sha256Hex(rawBytes),
+	}
+
+	if hashUrl := rulesHashUrl(url); hashUrl != "" {
+		want, err := fetchRulesHash(hashUrl)
+		if err != nil {
+			return "", newMeta, false, err
+		}
+		if !strings.EqualFold(newMeta.Sha256, want) {
+			return "", newMeta, false, fmt.Errorf("sha256 mismatch: got %s, published %s", newMeta.Sha256, want)
+		}
+	}
+
+	return string(rawBytes), newMeta, false, nil
+}
+
+func fetchRulesHash(hashUrl string) (string, error) {
+	resp, err := rulesHttpClient.Get(hashUrl)
+	if err != nil {
+		return "", fmt.Errorf("get hash: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get hash: unexpected status %s", resp.Status)
+	}
+	hashBytes, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("readAll hash: %w", err)
+	}
+	hash := strings.TrimSpace(string(hashBytes))
+	if len(hash) != sha256.Size*2 {
+		return "", fmt.Errorf("get hash: malformed hash %q", hash)
+	}
+	return hash, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// FetchAndLoadRules loads the ClearURLs rules at url with the local custom rules and aliases on top
+func FetchAndLoadRules(url string) (*Data, error) {
+	return LoadRules([]string{url, CUSTOM_RULES_FILE, ALIAS_FILE})
+}
+
+// LoadRules loads the rule sources in order and merges them into one ruleset:
+//   - Sources are urls (http:// or https://), files or directories. A directory stands for
+//     the *.json files directly inside it, sorted by name.
+//   - The first source is the base. If it is a url that can't be fetched and has no cache,
+//     the bundled rules are used instead. Later urls in the same situation are skipped.
+//     Missing local files are skipped, broken ones fail the whole load.
+//   - A provider key seen for the first time is added as is, it must have a urlPattern.
+//     A key seen again keeps its urlPattern and gets the new rules, exceptions, etc. appended;
+//     completeProvider stays on once any source turns it on.
+//   - globalRules from every source are merged that way too, whatever their urlPattern is.
+//   - An entry can override instead of extend: "disabled": true drops the provider,
+//     "replace": true swaps in this entry, which needs a urlPattern, as a whole and "remove": {"rules": [...], ...} takes
+//     patterns out before this entry's own patterns are appended.
+//   - Providers touched by later sources are checked before the ones only from earlier sources.
+//   - Aliases from every source are applied once all providers are loaded, a later alias with
+//     the same key replaces an earlier one. See applyAliases.
+func LoadRules(sources []string) (*Data, error) {
+	return loadRules(sources, rawReader(readOptions{}))
+}
+
+// loadRules is LoadRules, every source is read with read
+func loadRules(sources []string, read readRaw) (*Data, error) {
+	data := Data{
+		Providers:      make(map[string]Provider),
+		LoadedAt:       time.Now(),
+		patternSources: make(map[*regexp2.Regexp]string),
+		aliasKeys:      make(map[*regexp2.Regexp]string),
+	}
+	aliases := make(map[string]rawAlias)
+
+	priority := 0
+	for i, source := range sources {
+		locations, err := expandRuleSource(source)
+		if err != nil {
+			log.Printf("Skipping rule source %s: %v", source, err)
+			continue
+		}
+
+		for _, location := range locations {
+			file, source, err := readRuleSource(location, i == 0, read)
+			if errors.Is(err, os.ErrNotExist) {
+				log.Printf("Skipping rule source %s: %v", location, err)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("rule source %s: %w", location, err)
+			}
+
+			err = data.mergeProviders(file.Providers, location, priority)
+			if err != nil {
+				return nil, fmt.Errorf("rule source %s: %w", location, err)
+			}
+			for key, alias := range file.Aliases {
+				alias.location = location
+				aliases[key] = alias
+			}
+			data.Sources = append(data.Sources, source)
+			log.Printf("Loaded rule source %s (%s).", location, source.Status)
+			priority++
+		}
+	}
+
+	if data.GlobalRules.UrlPattern == nil {
+		data.GlobalRules = Provider{Name: "globalRules", UrlPattern: regexp2.MustCompile(".*", regexp2.None)}
+	}
+
+	err := data.applyAliases(aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	data.buildIndex()
+	return &data, nil
+}
+
+// applyAliases adds the urlPattern of every alias to the providers it targets, so urls matching it
+// are cleaned by those providers themselves. Targets may be provider keys, globalRules or other aliases,
+// which stand for all the providers they resolve to. Aliases that can't be resolved are skipped with a warning.
+func (d *Data) applyAliases(aliases map[string]rawAlias) error {
+	resolved, problems := resolveAliases(aliases, func(key string) bool {
+		_, ok := d.Providers[key]
+		return ok
+	})
+	for _, problem := range problems {
+		log.Println(problem)
+	}
+
+	keys := make([]string, 0, len(resolved))
+	for key := range resolved {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Stable order of the patterns added to each provider
+
+	d.Aliases = make(map[string][]string, len(resolved))
+	for _, key := range keys {
+		targets := resolved[key]
+		urlPattern, err := regexp2.Compile(aliases[key].UrlPatternStr, regexp2.None)
+		if err != nil {
+			return fmt.Errorf("failed to compile UrlPattern for alias %s: %v", key, err)
+		}
+		d.patternSources[urlPattern] = aliases[key].location
+		d.aliasKeys[urlPattern] = key
+		// Every target shares the same compiled pattern, see cleanUrl
+		for _, target := range targets {
+			if target == "globalRules" {
+				d.GlobalRules.Aliases = append(d.GlobalRules.Aliases, urlPattern)
+				continue
+			}
+			provider := d.Providers[target]
+			provider.Aliases = append(provider.Aliases, urlPattern)
+			d.Providers[target] = provider
+		}
+		d.Aliases[key] = targets
+	}
+	return nil
+}
+
+// resolveAliases follows every alias down to the provider keys it ends up at. Aliases in a cycle
+// or without any loaded target are left out of resolved, every problem found is described in problems.
+func resolveAliases(aliases map[string]rawAlias, hasProvider func(key string) bool) (resolved map[string][]string, problems []string) {
+	resolved = make(map[string][]string, len(aliases))
+	reported := make(map[string]bool)
+	missingTarget := make(map[string]bool)
+	var resolve func(key string, path []string) ([]string, error)
+	resolve = func(key string, path []string) ([]string, error) {
+		if targets, ok := resolved[key]; ok {
+			return targets, nil
+		}
+		for i, seen := range path {
+			if seen == key {
+				return nil, fmt.Errorf("alias cycle %s", strings.Join(append(path[i:], key), " -> "))
+			}
+		}
+		path = append(path, key)
+
+		var targets []string
+		for _, target := range aliases[key].targets() {
+			if hasProvider(target) || target == "globalRules" {
+				targets = appendUnique(targets, target)
+				continue
+			}
+			if _, isAlias := aliases[target]; isAlias {
+				aliasTargets, err := resolve(target, path)
+				if err != nil {
+					return nil, err
+				}
+				targets = appendUnique(targets, aliasTargets...)
+				continue
+			}
+			problem := fmt.Sprintf("Skipping target %s of alias %s: neither a provider nor an alias", target, key)
+			if !reported[problem] {
+				reported[problem] = true
+				problems = append(problems, problem)
+			}
+			missingTarget[key] = true
+		}
+		if len(targets) > 0 {
+			resolved[key] = targets
+		}
+		return targets, nil
+	}
+
+	keys := make([]string, 0, len(aliases))
+	for key := range aliases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		targets, err := resolve(key, nil)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Skipping alias %s: %v", key, err))
+			continue
+		}
+		if len(targets) == 0 && !missingTarget[key] {
+			problems = append(problems, fmt.Sprintf("Skipping alias %s: no target provider loaded", key))
+		}
+	}
+	return resolved, problems
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func isRemoteRuleSource(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// expandRuleSource turns a directory into the sorted *.json files inside it, anything else stays as is
+func expandRuleSource(source string) ([]string, error) {
+	if isRemoteRuleSource(source) {
+		return []string{source}, nil
+	}
+	fi, err := os.Stat(source)
+	if err != nil || !fi.IsDir() {
+		return []string{source}, nil // Reported when read
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, filepath.Join(source, entry.Name()))
+		}
+	}
+	return files, nil // ReadDir already sorts by name
+}
+
+// readRuleSource reads and decodes a single url or file, remote failures are reported as os.ErrNotExist
+func readRuleSource(location string, base bool, read readRaw) (file rulesFile, source LoadedRuleSource, err error) {
+	raw, status, err := read(location, base)
+	if err != nil {
+		return file, source, err
+	}
+
+	err = json.NewDecoder(strings.NewReader(raw)).Decode(&file)
+	if err != nil && base && isRemoteRuleSource(location) && status != RULES_SOURCE_BUNDLED {
+		log.Printf("Failed to decode rules from %s (%s), using bundled rules: %v", location, status, err)
+		file, status, raw = rulesFile{}, RULES_SOURCE_BUNDLED, string(bundledRules)
+		err = json.Unmarshal(bundledRules, &file)
+	}
+	if err != nil {
+		return file, source, fmt.Errorf("decode: %w", err)
+	}
+	return file, LoadedRuleSource{Location: location, Status: status, Sha256: sha256Hex([]byte(raw))}, nil
+}
+
+// readRaw returns the text of a single url or file and where it came from, one of the RULES_SOURCE_* values
+type readRaw func(location string, base bool) (raw string, status string, err error)
+
+// rawReader reads with readRuleSourceRaw
+func rawReader(opts readOptions) readRaw {
+	return func(location string, base bool) (string, string, error) {
+		return readRuleSourceRaw(location, base, opts)
+	}
+}
+
+// readRuleSourceRaw returns the text of a single url or file, see readRuleSource
+func readRuleSourceRaw(location string, base bool, opts readOptions) (raw string, status string, err error) {
+	if isRemoteRuleSource(location) {
+		raw, status, ok := loadOnlineRules(location, rulesCacheFile(location, base), base, opts)
+		if !ok {
+			return "", "", fmt.Errorf("unreachable and not cached: %w", os.ErrNotExist)
+		}
+		return raw, status, nil
+	}
+	rawBytes, err := os.ReadFile(location)
+	if err != nil {
+		return "", "", err
+	}
+	return string(rawBytes), RULES_SOURCE_FILE, nil
+}
+
+// mergeProviders compiles the providers of the source at location into data, see LoadRules for the rules of merging
+func (d *Data) mergeProviders(rawProviders map[string]rawProvider, location string, priority int) error {
+	for key, rawProvider := range rawProviders {
+		if key == "globalRules" {
+			err := d.overrideProvider(&d.GlobalRules, key, rawProvider, location, priority)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		existing, ok := d.Providers[key]
+		if !ok && rawProvider.Disabled {
+			log.Printf("Nothing to disable for provider %s", key)
+			continue
+		}
+		if !ok && rawProvider.UrlPatternStr == "" {
+			log.Printf("Skipping provider %s: no urlPattern and nothing to extend", key)
+			continue
+		}
+		err := d.overrideProvider(&existing, key, rawProvider, location, priority)
+		if err != nil {
+			return err
+		}
+		if existing.UrlPattern == nil {
+			delete(d.Providers, key)
+			continue
+		}
+		// Add compiled provider to the map
+		d.Providers[key] = existing
+	}
+	return nil
+}
+
+// overrideProvider applies one rule source's entry for key to existing, which has a nil UrlPattern
+// if nothing was loaded before. Disabling leaves existing zeroed.
+func (d *Data) overrideProvider(existing *Provider, key string, rawProvider rawProvider, location string, priority int) error {
+	if rawProvider.Disabled {
+		*existing = Provider{}
+		return nil
+	}
+	if rawProvider.Replace && rawProvider.UrlPatternStr == "" {
+		return fmt.Errorf("provider %s: replace without a urlPattern would match every url", key)
+	}
+
+	provider, err := makeProvider(key, rawProvider)
+	if err != nil {
+		return fmt.Errorf("failed to make provider %s: %v", key, err)
+	}
+	provider.Priority = priority
+	d.recordSources(provider, location)
+
+	if existing.UrlPattern == nil || rawProvider.Replace {
+		*existing = provider
+		return nil
+	}
+	if rawProvider.Remove != nil {
+		removeFromProvider(existing, *rawProvider.Remove)
+	}
+	mergeProvider(existing, provider)
+	return nil
+}
+
+// removeFromProvider drops the patterns listed in remove from dst
+func removeFromProvider(dst *Provider, remove rawProvider) {
+	dst.Rules = removePatterns(dst.Name, dst.Rules, remove.RulesStr)
+	dst.Exceptions = removePatterns(dst.Name, dst.Exceptions, remove.ExceptionsStr)
+	dst.IgnoredParameters = removePatterns(dst.Name, dst.IgnoredParameters, remove.IgnoredParametersStr)
+	dst.Redirections = removePatterns(dst.Name, dst.Redirections, remove.RedirectionsStr)
+	dst.SafeParameters = removePatterns(dst.Name, dst.SafeParameters, remove.SafeParametersStr)
+	dst.RawRules = removePatterns(dst.Name, dst.RawRules, remove.RawRulesStr)
+	dst.ReferralMarketing = removePatterns(dst.Name, dst.ReferralMarketing, remove.ReferralMarketingStr)
+	dst.FragmentRules = removePatterns(dst.Name, dst.FragmentRules, remove.FragmentRulesStr)
+	if remove.CompleteProvider {
+		dst.CompleteProvider = false
+	}
+}
+
+// removePatterns returns patterns without the ones whose source is in remove
+func removePatterns(name string, patterns []*regexp2.Regexp, remove []string) []*regexp2.Regexp {
+	if len(remove) == 0 {
+		return patterns
+	}
+	removed := make(map[string]bool, len(remove))
+	for _, pattern := range remove {
+		removed[pattern] = false
+	}
+	kept := make([]*regexp2.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, ok := removed[pattern.String()]; ok {
+			removed[pattern.String()] = true
+			continue
+		}
+		kept = append(kept, pattern)
+	}
+	for _, pattern := range remove {
+		if !removed[pattern] {
+			log.Printf("Nothing to remove for %s in provider %s", pattern, name)
+		}
+	}
+	return kept
+}
+
+// mergeProvider appends everything but the urlPattern of src to dst
+func mergeProvider(dst *Provider, src Provider) {
+	dst.Rules = append(dst.Rules, src.Rules...)
+	dst.Exceptions = append(dst.Exceptions, src.Exceptions...)
+	dst.IgnoredParameters = append(dst.IgnoredParameters, src.IgnoredParameters...)
+	dst.Redirections = append(dst.Redirections, src.Redirections...)
+	dst.SafeParameters = append(dst.SafeParameters, src.SafeParameters...)
+	dst.RawRules = append(dst.RawRules, src.RawRules...)
+	dst.ReferralMarketing = append(dst.ReferralMarketing, src.ReferralMarketing...)
+	dst.FragmentRules = append(dst.FragmentRules, src.FragmentRules...)
+	dst.CompleteProvider = dst.CompleteProvider || src.CompleteProvider
+	dst.Priority = src.Priority
+}
+
+// buildIndex sorts the providers by priority and indexes them by the host label their patterns require.
+// Providers from later rule sources come before earlier ones, indexable (more specific) patterns before generic ones, then by key.
+func (d *Data) buildIndex() {
+	type entry struct {
+		key      string
+		provider Provider
+		labels   []string
+	}
+
+	entries := make([]entry, 0, len(d.Providers))
+	for key, provider := range d.Providers {
+		labels, _ := providerHostLabels(provider)
+		entries = append(entries, entry{key: key, provider: provider, labels: labels})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.provider.Priority != b.provider.Priority {
+			return a.provider.Priority > b.provider.Priority
+		}
+		if (a.labels == nil) != (b.labels == nil) {
+			return a.labels != nil
+		}
+		return a.key < b.key
+	})
+
+	d.ordered = make([]Provider, 0, len(entries))
+	d.domainIndex = make(map[string][]int)
+	d.generic = nil
+	for i, e := range entries {
+		d.ordered = append(d.ordered, e.provider)
+		if e.labels == nil {
+			d.generic = append(d.generic, i)
+			continue
+		}
+		for _, label := range e.labels {
+			if positions := d.domainIndex[label]; len(positions) > 0 && positions[len(positions)-1] == i {
+				continue
+			}
+			d.domainIndex[label] = append(d.domainIndex[label], i)
+		}
+	}
+}
+
+// providerHostLabels collects the host labels of the urlPattern and every alias,
+// a provider is only indexable if all of them are
+func providerHostLabels(provider Provider) ([]string, bool) {
+	label, ok := patternHostLabel(provider.UrlPattern.String())
+	if !ok {
+		return nil, false
+	}
+	labels := []string{label}
+	for _, alias := range provider.Aliases {
+		aliasLabel, ok := patternHostLabel(alias.String())
+		if !ok {
+			return nil, false
+		}
+		labels = append(labels, aliasLabel)
+	}
+	return labels, true
+}
+
+// candidates returns the providers that may match the url, in priority order
+func (d *Data) candidates(url string) []Provider {
+	var positions []int
+	positions = append(positions, d.generic...)
+	for _, label := range urlHostLabels(url) {
+		positions = append(positions, d.domainIndex[label]...)
+	}
+	sort.Ints(positions)
+
+	result := make([]Provider, 0, len(positions))
+	for i, pos := range positions {
+		if i > 0 && positions[i-1] == pos {
+			continue
+		}
+		result = append(result, d.ordered[pos])
+	}
+	return result
+}
+
+// urlHostLabels splits the authority part of the url into labels, userinfo and port included
+func urlHostLabels(url string) []string {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return nil
+	}
+	authority := url[i+3:]
+	if j := strings.IndexAny(authority, "/?#"); j >= 0 {
+		authority = authority[:j]
+	}
+	return strings.FieldsFunc(authority, func(r rune) bool {
+		return r == '.' || r == '@' || r == ':'
+	})
+}
+
+var urlPatternSchemes = []string{`^https?:\/\/`, `^https:\/\/`, `^http:\/\/`}
+var urlPatternSubdomains = []string{`(?:[a-z0-9-]+\.)*?`, `(?:[a-z0-9-]+\.)*`, `([a-z0-9-]+\.)*?`, `(?:[a-z0-9-]+\.)?`, `(?:www\.)?`}
+var urlPatternLabelEnds = []string{`\.`, `\/`, `:`, `(?:\.[a-z]{2,}){1,}`, `(?:\.[a-z]{2,})+`}
+
+// patternHostLabel finds the whole host label every url matched by the pattern must contain,
+// e.g. "amazon" for ^https?:\/\/(?:[a-z0-9-]+\.)*?amazon(?:\.[a-z]{2,}){1,}
+// Patterns too complex to be sure about are reported as not indexable.
+func patternHostLabel(pattern string) (string, bool) {
+	if hasTopLevelAlternation(pattern) {
+		return "", false
+	}
+
+	rest, ok := trimAnyPrefix(pattern, urlPatternSchemes)
+	if !ok {
+		return "", false
+	}
+	rest, _ = trimAnyPrefix(rest, urlPatternSubdomains)
+
+	end := 0
+	for end < len(rest) && isLabelByte(rest[end]) {
+		end++
+	}
+	label, rest := rest[:end], rest[end:]
+	if label == "" {
+		return "", false
+	}
+
+	for _, labelEnd := range urlPatternLabelEnds {
+		if !strings.HasPrefix(rest, labelEnd) {
+			continue
+		}
+		// The terminator itself must not be optional
+		if after := rest[len(labelEnd):]; after != "" && strings.ContainsRune("?*{", rune(after[0])) {
+			return "", false
+		}
+		return label, true
+	}
+	return "", false
+}
+
+func trimAnyPrefix(s string, prefixes []string) (string, bool) {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return s[len(prefix):], true
+		}
+	}
+	return s, false
+}
+
+func isLabelByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
+
+func hasTopLevelAlternation(pattern string) bool {
+	depth := 0
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			return true
+		}
+	}
+	return false
+}
+
+func makeProvider(key string, rawProvider rawProvider) (provider Provider, err error) {
+	provider = Provider{Name: key}
+
+	// Compile urlPattern
+	provider.UrlPattern, err = regexp2.Compile(rawProvider.UrlPatternStr, regexp2.None)
+	if err != nil {
+		return provider, fmt.Errorf("failed to compile UrlPattern for provider %s: %v", key, err)
+	}
+
+	// Compile rules
+	for _, ruleStr := range rawProvider.RulesStr {
+		rule, err := regexp2.Compile(ruleStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile rule for provider %s: %v", key, err)
+		}
+		provider.Rules = append(provider.Rules, rule)
+	}
+
+	// Compile exceptions
+	for _, exceptionStr := range rawProvider.ExceptionsStr {
+		exception, err := regexp2.Compile(exceptionStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile exception for provider %s: %v", key, err)
+		}
+		provider.Exceptions = append(provider.Exceptions, exception)
+	}
+
+	// Compile ignored parameters
+	for _, ignoredParamStr := range rawProvider.IgnoredParametersStr {
+		ignoredParam, err := regexp2.Compile(ignoredParamStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile ignored parameter for provider %s: %v", key, err)
+		}
+		provider.IgnoredParameters = append(provider.IgnoredParameters, ignoredParam)
+	}
+
+	// Compile redirections
+	for _, redirectionStr := range rawProvider.RedirectionsStr {
+		redirection, err := regexp2.Compile(redirectionStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile redirection for provider %s: %v", key, err)
+		}
+		provider.Redirections = append(provider.Redirections, redirection)
+	}
+
+	// Compile safe parameters
+	for _, safeParamStr := range rawProvider.SafeParametersStr {
+		safeParam, err := regexp2.Compile(safeParamStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile safe parameter for provider %s: %v", key, err)
+		}
+		provider.SafeParameters = append(provider.SafeParameters, safeParam)
+	}
+
+	// Compile raw rules
+	for _, rawRuleStr := range rawProvider.RawRulesStr {
+		rawRule, err := regexp2.Compile(rawRuleStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile raw rule for provider %s: %v", key, err)
+		}
+		provider.RawRules = append(provider.RawRules, rawRule)
+	}
+
+	// Compile referral marketing rules
+	for _, referralStr := range rawProvider.ReferralMarketingStr {
+		referral, err := regexp2.Compile(referralStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile referral marketing rule for provider %s: %v", key, err)
+		}
+		provider.ReferralMarketing = append(provider.ReferralMarketing, referral)
+	}
+
+	// Compile fragment rules
+	for _, fragmentRuleStr := range rawProvider.FragmentRulesStr {
+		fragmentRule, err := regexp2.Compile(fragmentRuleStr, regexp2.None)
+		if err != nil {
+			return provider, fmt.Errorf("failed to compile fragment rule for provider %s: %v", key, err)
+		}
+		provider.FragmentRules = append(provider.FragmentRules, fragmentRule)
+	}
+
+	provider.CompleteProvider = rawProvider.CompleteProvider
+
+	return provider, nil
+}
+
+// let d = [{
+// 	character: "h",
+// 	matcher: f(["H", "һ", "հ", "Ꮒ", "ℎ", "\uD835\uDC21", "\uD835\uDC89", "\uD835\uDCBD", "\uD835\uDCF1", "\uD835\uDD25", "\uD835\uDD59", "\uD835\uDD8D", "\uD835\uDDC1", "\uD835\uDDF5", "\uD835\uDE29", "\uD835\uDE5D", "\uD835\uDE91", "ｈ"])
+// }, {
+// 	character: "t",
+// 	matcher: f(["T", "\uD835\uDC2D", "\uD835\uDC61", "\uD835\uDC95", "\uD835\uDCC9", "\uD835\uDCFD", "\uD835\uDD31", "\uD835\uDD65", "\uD835\uDD99", "\uD835\uDDCD", "\uD835\uDE01", "\uD835\uDE35", "\uD835\uDE69", "\uD835\uDE9D"])
+// }, {
+// 	character: "p",
+// 	matcher: f(["P", "ρ", "ϱ", "р", "⍴", "ⲣ", "\uD835\uDC29", "\uD835\uDC5D", "\uD835\uDC91", "\uD835\uDCC5", "\uD835\uDCF9", "\uD835\uDD2D", "\uD835\uDD61", "\uD835\uDD95", "\uD835\uDDC9", "\uD835\uDDFD", "\uD835\uDE31", "\uD835\uDE65", "\uD835\uDE99", "\uD835\uDED2", "\uD835\uDEE0", "\uD835\uDF0C", "\uD835\uDF1A", "\uD835\uDF46", "\uD835\uDF54", "\uD835\uDF80", "\uD835\uDF8E", "\uD835\uDFBA", "\uD835\uDFC8", "ｐ", "ҏ"])
+// }, {
+// 	character: "s",
+// 	matcher: f(["S", "ƽ", "ѕ", "ꜱ", "ꮪ", "\uD801\uDC48", "\uD806\uDCC1", "\uD835\uDC2C", "\uD835\uDC60", "\uD835\uDC94", "\uD835\uDCC8", "\uD835\uDCFC", "\uD835\uDD30", "\uD835\uDD64", "\uD835\uDD98", "\uD835\uDDCC", "\uD835\uDE00", "\uD835\uDE34", "\uD835\uDE68", "\uD835\uDE9C", "ｓ"])
+// }, {
+// 	character: ":",
+// 	matcher: f(["ː", "˸", "։", "׃", "܃", "܄", "ः", "ઃ", "᛬", "᠃", "᠉", "⁚", "∶", "ꓽ", "꞉", "︰", "：", ";", ";"])
+// }, {
+// 	character: "/",
+// 	matcher: f(["᜵", "⁁", "⁄", "∕", "╱", "⟋", "⧸", "Ⳇ", "⼃", "〳", "ノ", "㇓", "丿", "\uD834\uDE3A"])
+// }];
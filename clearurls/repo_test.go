@@ -0,0 +1,484 @@
+package clearurls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatternHostLabel(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		want      string
+		wantIndex bool
+	}{
+		{"tldWildcard", `^https?:\/\/(?:[a-z0-9-]+\.)*?amazon(?:\.[a-z]{2,}){1,}`, "amazon", true},
+		{"escapedDot", `^https?:\/\/(?:[a-z0-9-]+\.)*?threads\.com`, "threads", true},
+		{"noSubdomain", `^https?:\/\/vxtwitter\.com`, "vxtwitter", true},
+		{"fixedSubdomain", `^https:\/\/cdn\.discordapp\.com\/(emojis|stickers)\/.+\.webp`, "cdn", true},
+		{"unescapedDot", `^https?:\/\/(?:[a-z0-9-]+\.)*?ettoday.net`, "", false},
+		{"optionalDot", `^https?:\/\/(?:[a-z0-9-]+\.)*?youtu\.?be`, "", false},
+		{"openEnded", `^https?:\/\/(?:[a-z0-9-]+\.)*?google`, "", false},
+		{"alternation", `^https?:\/\/amazon\.com|^https?:\/\/amzn\.to`, "", false},
+		{"alternationInGroup", `^https?:\/\/(?:www\.)?reddit\.(?:com|de)`, "reddit", true},
+		{"unanchored", `https?:\/\/amazon\.com`, "", false},
+		{"matchAll", `.*`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotIndex := patternHostLabel(tt.pattern)
+			if got != tt.want || gotIndex != tt.wantIndex {
+				t.Errorf("patternHostLabel() = %v, %v, want %v, %v", got, gotIndex, tt.want, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func TestDataCandidates(t *testing.T) {
+	providers := map[string]rawProvider{
+		"amazon":  {UrlPatternStr: `^https?:\/\/(?:[a-z0-9-]+\.)*?amazon(?:\.[a-z]{2,}){1,}`},
+		"ettoday": {UrlPatternStr: `^https?:\/\/(?:[a-z0-9-]+\.)*?ettoday.net`},
+		"threads": {UrlPatternStr: `^https?:\/\/(?:[a-z0-9-]+\.)*?threads\.com`},
+		"zcustom": {UrlPatternStr: `^https?:\/\/(?:[a-z0-9-]+\.)*?threads\.com\/@`},
+	}
+	data := Data{Providers: make(map[string]Provider)}
+	for key, raw := range providers {
+		provider, err := makeProvider(key, raw)
+		if err != nil {
+			t.Fatalf("makeProvider() error = %v", err)
+		}
+		data.Providers[key] = provider
+	}
+	custom := data.Providers["zcustom"]
+	custom.Priority = 1
+	data.Providers["zcustom"] = custom
+	data.buildIndex()
+
+	tests := []struct {
+		name string
+		url  string
+		want []string
+	}{
+		{"indexed", "https://www.amazon.co.jp/dp/1", []string{"amazon", "ettoday"}},
+		{"customFirst", "https://www.threads.com/@someone", []string{"zcustom", "threads", "ettoday"}},
+		{"genericOnly", "https://example.com/", []string{"ettoday"}},
+		{"userinfo", "https://amazon.com@example.com/", []string{"amazon", "ettoday"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, provider := range data.candidates(tt.url) {
+				got = append(got, provider.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("candidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// chdirTemp runs the rest of the test inside an empty temporary directory,
+// since the rule files are looked up relative to the working directory
+func chdirTemp(t testing.TB) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() error = %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir() error = %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+const testOnlineRules = `{"providers":{"globalRules":{"urlPattern":".*","rules":["utm_source"]},"example":{"urlPattern":"^https?:\\/\\/example\\.com","rules":["ref"]}}}`
+
+func TestFetchAndLoadRulesFallback(t *testing.T) {
+	online := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testOnlineRules))
+	}))
+	defer online.Close()
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+
+	tests := []struct {
+		name          string
+		url           string
+		cacheAge      time.Duration // 0 = no cache
+		wantSource    string
+		wantProvider  string
+		wantCacheFile bool
+	}{
+		{"online", online.URL, 0, RULES_SOURCE_ONLINE, "example", true},
+		{"freshCache", offline.URL, time.Minute, RULES_SOURCE_CACHE, "cached", true},
+		{"staleCache", offline.URL, RULES_CACHE_MAX_AGE * 2, RULES_SOURCE_STALE, "cached", true},
+		{"bundled", offline.URL, 0, RULES_SOURCE_BUNDLED, "youtube", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			if tt.cacheAge > 0 {
+				cached := strings.Replace(testOnlineRules, `"example"`, `"cached"`, 1)
+				if err := os.WriteFile(ONLINE_RULES_FILE, []byte(cached), 0644); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+				modTime := time.Now().Add(-tt.cacheAge)
+				if err := os.Chtimes(ONLINE_RULES_FILE, modTime, modTime); err != nil {
+					t.Fatalf("Chtimes() error = %v", err)
+				}
+			}
+
+			d, err := FetchAndLoadRules(tt.url)
+			if err != nil {
+				t.Fatalf("FetchAndLoadRules() error = %v", err)
+			}
+			if got := d.Sources[0].Status; got != tt.wantSource {
+				t.Errorf("Sources[0].Status = %v, want %v", got, tt.wantSource)
+			}
+			if _, ok := d.Providers[tt.wantProvider]; !ok {
+				t.Errorf("Providers = %v, want %v", d.Providers, tt.wantProvider)
+			}
+			if _, err := os.Stat(ONLINE_RULES_FILE); (err == nil) != tt.wantCacheFile {
+				t.Errorf("cache file exists = %v, want %v", err == nil, tt.wantCacheFile)
+			}
+		})
+	}
+}
+
+func TestFetchAndLoadRulesVerified(t *testing.T) {
+	const etag = `"v1"`
+	goodHash := sha256Hex([]byte(testOnlineRules))
+
+	type server struct {
+		status int
+		body   string
+		hash   string
+	}
+	newServer := func(t *testing.T, srv server, conditional *int) string {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/data.minify.json", func(w http.ResponseWriter, r *http.Request) {
+			if srv.status == http.StatusOK && r.Header.Get("If-None-Match") == etag {
+				*conditional++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(srv.status)
+			w.Write([]byte(srv.body))
+		})
+		mux.HandleFunc("/rules.minify.hash", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(srv.hash + "\n"))
+		})
+		ts := httptest.NewServer(mux)
+		t.Cleanup(ts.Close)
+		return ts.URL + "/data.minify.json"
+	}
+
+	tests := []struct {
+		name            string
+		server          server
+		staleCache      bool
+		wantSource      string
+		wantConditional int
+	}{
+		{"verified", server{http.StatusOK, testOnlineRules, goodHash}, false, RULES_SOURCE_ONLINE, 0},
+		{"hashMismatch", server{http.StatusOK, testOnlineRules[:len(testOnlineRules)/2], goodHash}, false, RULES_SOURCE_BUNDLED, 0},
+		{"errorPage", server{http.StatusInternalServerError, "<html>oops</html>", goodHash}, false, RULES_SOURCE_BUNDLED, 0},
+		{"errorPageStaleCache", server{http.StatusBadGateway, "<html>oops</html>", goodHash}, true, RULES_SOURCE_STALE, 0},
+		{"notModified", server{http.StatusOK, testOnlineRules, goodHash}, true, RULES_SOURCE_CACHE, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			conditional := 0
+			url := newServer(t, tt.server, &conditional)
+
+			if tt.staleCache {
+				err := writeRulesCache(ONLINE_RULES_FILE, testOnlineRules, rulesCacheMeta{ETag: etag, Sha256: goodHash})
+				if err != nil {
+					t.Fatalf("writeRulesCache() error = %v", err)
+				}
+				modTime := time.Now().Add(-RULES_CACHE_MAX_AGE * 2)
+				if err := os.Chtimes(ONLINE_RULES_FILE, modTime, modTime); err != nil {
+					t.Fatalf("Chtimes() error = %v", err)
+				}
+			}
+
+			d, err := FetchAndLoadRules(url)
+			if err != nil {
+				t.Fatalf("FetchAndLoadRules() error = %v", err)
+			}
+			if got := d.Sources[0].Status; got != tt.wantSource {
+				t.Errorf("Sources[0].Status = %v, want %v", got, tt.wantSource)
+			}
+			if conditional != tt.wantConditional {
+				t.Errorf("conditional requests = %v, want %v", conditional, tt.wantConditional)
+			}
+
+			if tt.wantSource == RULES_SOURCE_BUNDLED {
+				if _, err := os.Stat(ONLINE_RULES_FILE); err == nil {
+					t.Errorf("unverified rules were written to the cache")
+				}
+				return
+			}
+			_, modTime, meta, err := readRulesCache(ONLINE_RULES_FILE)
+			if err != nil {
+				t.Fatalf("readRulesCache(ONLINE_RULES_FILE) error = %v", err)
+			}
+			if meta.ETag != etag || meta.Sha256 != goodHash {
+				t.Errorf("meta = %+v, want etag %v and sha256 %v", meta, etag, goodHash)
+			}
+			if tt.wantSource != RULES_SOURCE_STALE && time.Since(modTime) > time.Minute {
+				t.Errorf("cache was not refreshed, modTime = %v", modTime)
+			}
+		})
+	}
+}
+
+func TestReadRulesCacheDamaged(t *testing.T) {
+	chdirTemp(t)
+	err := writeRulesCache(ONLINE_RULES_FILE, testOnlineRules, rulesCacheMeta{Sha256: sha256Hex([]byte(testOnlineRules))})
+	if err != nil {
+		t.Fatalf("writeRulesCache() error = %v", err)
+	}
+	if err := os.WriteFile(ONLINE_RULES_FILE, []byte(testOnlineRules[:10]), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, _, _, err := readRulesCache(ONLINE_RULES_FILE); err == nil {
+		t.Errorf("readRulesCache(ONLINE_RULES_FILE) error = nil, want sha256 mismatch")
+	}
+
+	// The meta of newer rules was written but not the rules themselves
+	err = writeRulesCache(ONLINE_RULES_FILE, testOnlineRules, rulesCacheMeta{})
+	if err != nil {
+		t.Fatalf("writeRulesCache() error = %v", err)
+	}
+	if err := os.WriteFile(rulesMetaFile(ONLINE_RULES_FILE), []byte(`{"sha256":"`+sha256Hex([]byte("newer"))+`"}`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, _, _, err := readRulesCache(ONLINE_RULES_FILE); err == nil {
+		t.Errorf("readRulesCache(ONLINE_RULES_FILE) error = nil, want sha256 mismatch")
+	}
+
+	if err := os.WriteFile(rulesMetaFile(ONLINE_RULES_FILE), []byte("{"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, _, _, err := readRulesCache(ONLINE_RULES_FILE); err == nil {
+		t.Errorf("readRulesCache(ONLINE_RULES_FILE) error = nil, want a broken meta to be a miss")
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	const community = `{"providers":{
+		"globalRules":{"urlPattern":"^https:\\/\\/","rules":["community_global"]},
+		"example":{"urlPattern":"^https?:\\/\\/other\\.com","rules":["community"]},
+		"shared":{"urlPattern":"^https?:\\/\\/shared\\.com","rules":["tracker"]}}}`
+	online := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/community.json" {
+			w.Write([]byte(community))
+			return
+		}
+		w.Write([]byte(testOnlineRules))
+	}))
+	defer online.Close()
+	offline := httptest.NewServer(http.NotFoundHandler())
+	offline.Close()
+
+	dir := chdirTemp(t)
+	if err := os.Mkdir("rules.d", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	files := map[string]string{
+		"rules.d/10-local.json":   `{"providers":{"shared":{"rules":["local"],"completeProvider":true},"orphan":{"rules":["x"]}}}`,
+		"rules.d/20-aliases.json": `{"aliases":{"mirror":{"urlPattern":"^https?:\\/\\/mirror\\.com","targetRuleName":"shared"}}}`,
+		"rules.d/notes.txt":       `not json`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	d, err := LoadRules([]string{online.URL, online.URL + "/community.json", offline.URL + "/gone.json", "missing.json", "rules.d"})
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	wantSources := []LoadedRuleSource{
+		{online.URL, RULES_SOURCE_ONLINE, sha256Hex([]byte(testOnlineRules))},
+		{online.URL + "/community.json", RULES_SOURCE_ONLINE, sha256Hex([]byte(community))},
+		{filepath.Join("rules.d", "10-local.json"), RULES_SOURCE_FILE, sha256Hex([]byte(files["rules.d/10-local.json"]))},
+		{filepath.Join("rules.d", "20-aliases.json"), RULES_SOURCE_FILE, sha256Hex([]byte(files["rules.d/20-aliases.json"]))},
+	}
+	if !reflect.DeepEqual(d.Sources, wantSources) {
+		t.Errorf("Sources = %v, want %v", d.Sources, wantSources)
+	}
+
+	// Extending sources keep the first urlPattern and append their rules
+	example := d.Providers["example"]
+	if got := example.UrlPattern.String(); got != `^https?:\/\/example\.com` {
+		t.Errorf("example urlPattern = %v, want the upstream one", got)
+	}
+	if len(example.Rules) != 2 {
+		t.Errorf("example rules = %v, want upstream and community", example.Rules)
+	}
+	// globalRules are merged even though the urlPatterns differ
+	if len(d.GlobalRules.Rules) != 2 {
+		t.Errorf("globalRules rules = %v, want upstream and community", d.GlobalRules.Rules)
+	}
+	shared := d.Providers["shared"]
+	if len(shared.Rules) != 2 || !shared.CompleteProvider {
+		t.Errorf("shared = %v rules, completeProvider %v, want 2 rules and true", len(shared.Rules), shared.CompleteProvider)
+	}
+	if _, ok := d.Providers["orphan"]; ok {
+		t.Errorf("orphan provider without urlPattern was added")
+	}
+	if got := d.Aliases["mirror"]; !reflect.DeepEqual(got, []string{"shared"}) {
+		t.Errorf("alias from a later file was not applied, targets = %v", got)
+	}
+	// Providers extended by later sources are checked first
+	if got := d.candidates("https://shared.com/")[0].Name; got != "shared" {
+		t.Errorf("candidates()[0] = %v, want shared", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, rulesCacheFile(online.URL+"/community.json", false))); err != nil {
+		t.Errorf("community rules were not cached: %v", err)
+	}
+
+	if err := os.WriteFile("rules.d/30-broken.json", []byte(`{`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := LoadRules([]string{online.URL, "rules.d"}); err == nil {
+		t.Errorf("LoadRules() error = nil, want decode error for a broken local file")
+	}
+}
+
+func TestLoadRulesOverrides(t *testing.T) {
+	const upstream = `{"providers":{
+		"globalRules":{"urlPattern":".*","rules":["utm_source","gclid"]},
+		"example":{"urlPattern":"^https?:\\/\\/example\\.com","rules":["ref","id"]},
+		"shortener":{"urlPattern":"^https?:\\/\\/short\\.link","completeProvider":true}}}`
+	online := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(upstream))
+	}))
+	defer online.Close()
+
+	tests := []struct {
+		name        string
+		custom      string
+		url         string
+		want        string
+		wantBlocked bool
+	}{
+		{"upstream", `{}`, "https://example.com/?id=1&ref=2&utm_source=x", "https://example.com/", false},
+		{"removeRule", `{"providers":{"example":{"remove":{"rules":["id"]}}}}`,
+			"https://example.com/?id=1&ref=2", "https://example.com/?id=1", false},
+		{"removeAndAdd", `{"providers":{"example":{"remove":{"rules":["id"]},"rules":["session"]}}}`,
+			"https://example.com/?id=1&session=2", "https://example.com/?id=1", false},
+		{"removeGlobalRule", `{"providers":{"globalRules":{"remove":{"rules":["utm_source"]}}}}`,
+			"https://example.com/?utm_source=x&gclid=y", "https://example.com/?utm_source=x", false},
+		{"disableProvider", `{"providers":{"example":{"disabled":true}}}`,
+			"https://example.com/?id=1&ref=2&utm_source=x", "https://example.com/?id=1&ref=2", false},
+		{"disableGlobalRules", `{"providers":{"globalRules":{"disabled":true}}}`,
+			"https://example.com/?ref=2&utm_source=x", "https://example.com/?utm_source=x", false},
+		{"replaceUrlPattern", `{"providers":{"example":{"replace":true,"urlPattern":"^https?:\\/\\/example\\.org","rules":["ref"]}}}`,
+			"https://example.com/?id=1&ref=2", "https://example.com/?id=1&ref=2", false},
+		{"replacedMatches", `{"providers":{"example":{"replace":true,"urlPattern":"^https?:\\/\\/example\\.org","rules":["ref"]}}}`,
+			"https://example.org/?id=1&ref=2", "https://example.org/?id=1", false},
+		{"blocked", `{}`, "https://short.link/abc", "https://short.link/abc", true},
+		{"unblock", `{"providers":{"shortener":{"remove":{"completeProvider":true}}}}`,
+			"https://short.link/abc", "https://short.link/abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			if err := os.WriteFile(CUSTOM_RULES_FILE, []byte(tt.custom), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+			d, err := FetchAndLoadRules(online.URL)
+			if err != nil {
+				t.Fatalf("FetchAndLoadRules() error = %v", err)
+			}
+			result := d.CleanUrl(tt.url)
+			got, gotBlocked := result.Processed, result.IsBlocked
+			if got != tt.want {
+				t.Errorf("CleanUrl() = %v, want %v", got, tt.want)
+			}
+			if gotBlocked != tt.wantBlocked {
+				t.Errorf("CleanUrl() blocked = %v, want %v", gotBlocked, tt.wantBlocked)
+			}
+		})
+	}
+
+	chdirTemp(t)
+	if err := os.WriteFile(CUSTOM_RULES_FILE, []byte(`{"providers":{"example":{"replace":true,"rules":["ref"]}}}`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := FetchAndLoadRules(online.URL); err == nil {
+		t.Errorf("FetchAndLoadRules() with replace but no urlPattern succeeded")
+	}
+}
+
+func TestApplyAliases(t *testing.T) {
+	const rules = `{"providers":{
+		"globalRules":{"urlPattern":"^https?:\\/\\/(?!localhost)","rules":["utm_source"]},
+		"x":{"urlPattern":"^https?:\\/\\/x\\.com","rules":["t"]},
+		"extra":{"urlPattern":"^https?:\\/\\/extra\\.com","rules":["s"]}},
+	"aliases":{
+		"fixvx":{"urlPattern":"^https?:\\/\\/fixvx\\.com","targetRuleName":"x"},
+		"mirror":{"urlPattern":"^https?:\\/\\/mirror\\.com","targetRuleName":"fixvx"},
+		"combined":{"urlPattern":"^https?:\\/\\/combined\\.com","targetRuleNames":["x","extra"]},
+		"local":{"urlPattern":"^https?:\\/\\/localhost","targetRuleName":"globalRules"},
+		"loopA":{"urlPattern":"^https?:\\/\\/a\\.com","targetRuleName":"loopB"},
+		"loopB":{"urlPattern":"^https?:\\/\\/b\\.com","targetRuleNames":["loopA","x"]},
+		"missing":{"urlPattern":"^https?:\\/\\/missing\\.com","targetRuleName":"nothing"}}}`
+	chdirTemp(t)
+	if err := os.WriteFile("rules.json", []byte(rules), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	d, err := LoadRules([]string{"rules.json"})
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	wantAliases := map[string][]string{
+		"fixvx":    {"x"},
+		"mirror":   {"x"},
+		"combined": {"x", "extra"},
+		"local":    {"globalRules"},
+	}
+	if !reflect.DeepEqual(d.Aliases, wantAliases) {
+		t.Errorf("Aliases = %v, want %v", d.Aliases, wantAliases)
+	}
+	if len(d.Providers) != 2 {
+		t.Errorf("Providers = %v, aliases should not be copied into providers", d.Providers)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"alias", "https://fixvx.com/?t=1&s=2", "https://fixvx.com/?s=2"},
+		{"aliasOfAlias", "https://mirror.com/?t=1&s=2", "https://mirror.com/?s=2"},
+		{"multipleTargets", "https://combined.com/?t=1&s=2&id=3", "https://combined.com/?id=3"},
+		{"globalRulesTarget", "http://localhost/?utm_source=x", "http://localhost/"},
+		{"cycleSkipped", "https://a.com/?t=1", "https://a.com/?t=1"},
+		{"missingSkipped", "https://missing.com/?t=1", "https://missing.com/?t=1"},
+		{"targetItself", "https://x.com/?t=1&s=2", "https://x.com/?s=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.CleanUrl(tt.url).Processed; got != tt.want {
+				t.Errorf("CleanUrl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
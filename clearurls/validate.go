@@ -0,0 +1,421 @@
+package clearurls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+)
+
+const (
+	SEVERITY_ERROR   = "error"
+	SEVERITY_WARNING = "warning"
+)
+
+// Issue is a single problem found in the rule sources by Validate
+type Issue struct {
+	Severity string // SEVERITY_ERROR or SEVERITY_WARNING
+	Location string // The file or url of the rule source
+	Entry    string // e.g. "provider amazon" or "alias fixvx", empty for the whole source
+	Field    string // e.g. "rules[3]"
+	Message  string
+}
+
+func (i Issue) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(i.Location)
+	if i.Entry != "" {
+		sb.WriteString(": ")
+		sb.WriteString(i.Entry)
+	}
+	if i.Field != "" {
+		sb.WriteString(": ")
+		sb.WriteString(i.Field)
+	}
+	fmt.Fprintf(&sb, ": %s: %s", i.Severity, i.Message)
+	return sb.String()
+}
+
+// readSource is a rule source as Validate read it
+type readSource struct {
+	raw    string
+	status string
+}
+
+// Validate checks the rule sources the way LoadRules reads them, but reports every problem instead of stopping at the first.
+// Every source is read once and the rule cache is left as it is.
+func Validate(sources []string) []Issue {
+	var issues []Issue
+	aliases := make(map[string]rawAlias)
+	defined := make(map[string]map[fieldPattern]bool) // Patterns of the providers loaded so far, to catch entries extending nothing
+	read := make(map[string]readSource)
+	readOnce := rawReader(readOptions{readOnly: true})
+
+	for i, source := range sources {
+		locations, err := expandRuleSource(source)
+		if err != nil {
+			issues = append(issues, Issue{Severity: SEVERITY_ERROR, Location: source, Message: err.Error()})
+			continue
+		}
+		for _, location := range locations {
+			raw, status, err := readOnce(location, i == 0)
+			if errors.Is(err, os.ErrNotExist) {
+				issues = append(issues, Issue{Severity: SEVERITY_WARNING, Location: location, Message: "skipped: " + err.Error()})
+				continue
+			}
+			if err != nil {
+				issues = append(issues, Issue{Severity: SEVERITY_ERROR, Location: location, Message: err.Error()})
+				continue
+			}
+			read[location] = readSource{raw, status}
+			if status == RULES_SOURCE_BUNDLED {
+				issues = append(issues, Issue{Severity: SEVERITY_WARNING, Location: location, Message: "unreachable and not cached, the bundled rules are checked instead"})
+			}
+
+			fileIssues, file := validateRulesFile(raw)
+			for _, issue := range fileIssues {
+				issue.Location = location
+				issues = append(issues, issue)
+			}
+			for key, alias := range file.Aliases {
+				aliases[key] = alias
+			}
+			issues = append(issues, checkExtends(location, file, defined)...)
+		}
+	}
+
+	for _, issue := range issues {
+		if issue.Severity == SEVERITY_ERROR {
+			return issues // Loading would fail anyway, the alias check needs a loadable ruleset
+		}
+	}
+
+	data, err := loadRules(sources, func(location string, base bool) (string, string, error) {
+		source, ok := read[location]
+		if !ok {
+			return "", "", fmt.Errorf("not read: %w", os.ErrNotExist) // Reported above already
+		}
+		return source.raw, source.status, nil
+	})
+	if err != nil {
+		return append(issues, Issue{Severity: SEVERITY_ERROR, Location: strings.Join(sources, ","), Message: err.Error()})
+	}
+	_, problems := resolveAliases(aliases, func(key string) bool {
+		_, ok := data.Providers[key]
+		return ok
+	})
+	for _, problem := range problems {
+		issues = append(issues, Issue{Severity: SEVERITY_WARNING, Location: strings.Join(sources, ","), Message: problem})
+	}
+	return issues
+}
+
+// fieldPattern is a pattern in one of the lists of a provider, like "rules"
+type fieldPattern struct {
+	field   string
+	pattern string
+}
+
+// checkExtends flags providers that only extend or override but have nothing loaded to apply to,
+// LoadRules skips those, and patterns to remove which no earlier source added.
+// defined is updated with the providers of this file.
+func checkExtends(location string, file rulesFile, defined map[string]map[fieldPattern]bool) []Issue {
+	var issues []Issue
+	keys := make([]string, 0, len(file.Providers))
+	for key := range file.Providers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		provider := file.Providers[key]
+		entry := "provider " + key
+		patterns, ok := defined[key]
+		switch {
+		case provider.Disabled:
+			if !ok && key != "globalRules" {
+				issues = append(issues, Issue{Severity: SEVERITY_WARNING, Location: location, Entry: entry, Message: "disables a provider no earlier source defines"})
+			}
+			delete(defined, key)
+			continue
+		case !ok && provider.UrlPatternStr == "" && key != "globalRules":
+			issues = append(issues, Issue{Severity: SEVERITY_WARNING, Location: location, Entry: entry, Message: "no urlPattern and no earlier source defines it, skipped"})
+			continue
+		case !ok || provider.Replace:
+			patterns = make(map[fieldPattern]bool)
+			defined[key] = patterns
+		}
+
+		if provider.Remove != nil {
+			for _, field := range provider.Remove.patternFields() {
+				for i, pattern := range field.patterns {
+					if !patterns[fieldPattern{field.name, pattern}] {
+						issues = append(issues, Issue{
+							Severity: SEVERITY_WARNING, Location: location, Entry: entry, Field: fmt.Sprintf("remove.%s[%d]", field.name, i),
+							Message: fmt.Sprintf("no earlier source adds %q, nothing to remove", pattern),
+						})
+					}
+					delete(patterns, fieldPattern{field.name, pattern})
+				}
+			}
+		}
+		for _, field := range provider.patternFields() {
+			for _, pattern := range field.patterns {
+				patterns[fieldPattern{field.name, pattern}] = true
+			}
+		}
+	}
+	return issues
+}
+
+// patternList is the patterns of a provider in one field, like "rules"
+type patternList struct {
+	name     string
+	patterns []string
+}
+
+// patternFields lists the patterns of p by field, in the order of rawProvider
+func (p rawProvider) patternFields() []patternList {
+	return []patternList{
+		{"rules", p.RulesStr},
+		{"exceptions", p.ExceptionsStr},
+		{"ignoredParameters", p.IgnoredParametersStr},
+		{"redirections", p.RedirectionsStr},
+		{"safeParameters", p.SafeParametersStr},
+		{"rawRules", p.RawRulesStr},
+		{"referralMarketing", p.ReferralMarketingStr},
+		{"fragmentRules", p.FragmentRulesStr},
+	}
+}
+
+// validateRulesFile checks a single rule source, the decoded file is returned for the alias check
+func validateRulesFile(raw string) ([]Issue, rulesFile) {
+	var issues []Issue
+	var file rulesFile
+	err := json.Unmarshal([]byte(raw), &file)
+	if err != nil {
+		return []Issue{{Severity: SEVERITY_ERROR, Message: "decode: " + err.Error()}}, file
+	}
+
+	for _, section := range []string{"providers", "aliases"} {
+		for _, key := range duplicateKeys(raw, section) {
+			issues = append(issues, Issue{
+				Severity: SEVERITY_WARNING,
+				Entry:    strings.TrimSuffix(section, "s") + " " + key,
+				Message:  "defined more than once in this file, only the last one is used",
+			})
+		}
+	}
+
+	keys := make([]string, 0, len(file.Providers))
+	for key := range file.Providers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		provider := file.Providers[key]
+		entry := "provider " + key
+		if _, ok := file.Aliases[key]; ok {
+			issues = append(issues, Issue{Severity: SEVERITY_WARNING, Entry: entry, Message: "also defined as an alias"})
+		}
+		if provider.UrlPatternStr != "" {
+			issues = append(issues, validatePattern(entry, "urlPattern", provider.UrlPatternStr, true)...)
+		} else if provider.Replace {
+			issues = append(issues, Issue{Severity: SEVERITY_ERROR, Entry: entry, Field: "replace", Message: "replace without a urlPattern would match every url"})
+		}
+		for _, field := range provider.patternFields() {
+			for i, pattern := range field.patterns {
+				issues = append(issues, validatePattern(entry, fmt.Sprintf("%s[%d]", field.name, i), pattern, false)...)
+			}
+		}
+	}
+
+	keys = keys[:0]
+	for key := range file.Aliases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		alias := file.Aliases[key]
+		entry := "alias " + key
+		if len(alias.targets()) == 0 {
+			issues = append(issues, Issue{Severity: SEVERITY_ERROR, Entry: entry, Message: "no targetRuleName or targetRuleNames"})
+		}
+		issues = append(issues, validatePattern(entry, "urlPattern", alias.UrlPatternStr, true)...)
+	}
+	return issues, file
+}
+
+func validatePattern(entry string, field string, pattern string, isUrlPattern bool) []Issue {
+	_, err := regexp2.Compile(pattern, regexp2.None)
+	if err != nil {
+		return []Issue{{Severity: SEVERITY_ERROR, Entry: entry, Field: field, Message: err.Error()}}
+	}
+	var issues []Issue
+	if isUrlPattern {
+		for _, host := range unescapedDots(pattern) {
+			issues = append(issues, Issue{
+				Severity: SEVERITY_WARNING, Entry: entry, Field: field,
+				Message: fmt.Sprintf("unescaped '.' in %q matches any character, use \\.", host),
+			})
+		}
+	}
+	if hasNestedQuantifier(pattern) {
+		issues = append(issues, Issue{
+			Severity: SEVERITY_WARNING, Entry: entry, Field: field,
+			Message: "repeated group ending in a repetition, prone to catastrophic backtracking",
+		})
+	}
+	return issues
+}
+
+// duplicateKeys lists the keys appearing more than once in the top level object named section,
+// encoding/json silently keeps the last one
+func duplicateKeys(raw string, section string) []string {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if tok != section {
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return nil
+			}
+			continue
+		}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return nil
+		}
+		seen := make(map[string]bool)
+		var duplicates []string
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return duplicates
+			}
+			key, _ := tok.(string)
+			if seen[key] {
+				duplicates = append(duplicates, key)
+			}
+			seen[key] = true
+			var skip json.RawMessage
+			if dec.Decode(&skip) != nil {
+				return duplicates
+			}
+		}
+		return duplicates
+	}
+	return nil
+}
+
+// unescapedDots finds host-like words joined by a bare '.', like fixvx.com, outside of character classes
+func unescapedDots(pattern string) []string {
+	var found []string
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '.' && i > 0 && i+1 < len(pattern) && isLabelByte(pattern[i-1]) && isLabelByte(pattern[i+1]):
+			start, end := i, i+1
+			for start > 0 && isLabelByte(pattern[start-1]) && (start < 2 || pattern[start-2] != '\\') {
+				start--
+			}
+			for end < len(pattern) && isLabelByte(pattern[end]) {
+				end++
+			}
+			found = append(found, pattern[start:end])
+		}
+	}
+	return found
+}
+
+// hasNestedQuantifier reports groups like (a+)+ or (?:x|\w*)* whose content can end in an unbounded
+// repetition and which are repeated without bound themselves. The ClearURLs subdomain prefix
+// (?:[a-z0-9-]+\.)*? is fine since every repetition must end with a literal dot.
+func hasNestedQuantifier(pattern string) bool {
+	type frame struct {
+		endsUnbounded    bool // The last atom so far is repeated without bound
+		altEndsUnbounded bool // An earlier alternative did
+	}
+	stack := []frame{{}}
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		top := &stack[len(stack)-1]
+		c := pattern[i]
+		switch {
+		case inClass:
+			if c == '\\' {
+				i++
+			} else if c == ']' {
+				inClass = false
+			}
+		case c == '\\':
+			i++
+			top.endsUnbounded = false
+		case c == '[':
+			inClass = true
+			top.endsUnbounded = false
+		case c == '(':
+			if i+1 < len(pattern) && pattern[i+1] == '?' {
+				// Skip the group specifier, (?:, (?=, (?<name>, etc.
+				j := strings.IndexAny(pattern[i+1:], ":=!>)")
+				if j >= 0 && pattern[i+1+j] != ')' {
+					i += 1 + j
+				}
+			}
+			stack = append(stack, frame{})
+		case c == ')':
+			if len(stack) == 1 {
+				continue
+			}
+			closed := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if quantifierUnbounded(pattern, i+1) && (closed.endsUnbounded || closed.altEndsUnbounded) {
+				return true
+			}
+			stack[len(stack)-1].endsUnbounded = false
+		case c == '|':
+			top.altEndsUnbounded = top.altEndsUnbounded || top.endsUnbounded
+			top.endsUnbounded = false
+		case c == '*' || c == '+' || c == '{':
+			if quantifierUnbounded(pattern, i) {
+				top.endsUnbounded = true
+			}
+		case c == '?':
+			// Lazy modifier or optional atom, neither changes whether the last atom is unbounded
+		default:
+			top.endsUnbounded = false
+		}
+	}
+	return false
+}
+
+// quantifierUnbounded tells if the quantifier at i is *, + or {n,}
+func quantifierUnbounded(pattern string, i int) bool {
+	if i >= len(pattern) {
+		return false
+	}
+	switch pattern[i] {
+	case '*', '+':
+		return true
+	case '{':
+		end := strings.IndexByte(pattern[i:], '}')
+		return end > 0 && strings.HasSuffix(pattern[i:i+end], ",")
+	}
+	return false
+}
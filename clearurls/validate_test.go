@@ -0,0 +1,157 @@
+package clearurls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string // Substrings of issue.String(), in order
+	}{
+		{
+			name: "clean",
+			files: map[string]string{
+				"rules.json": `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com","rules":["t"]}}}`,
+			},
+			want: nil,
+		},
+		{
+			name: "badPattern",
+			files: map[string]string{
+				"rules.json": `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com","rules":["t","(unclosed"]}}}`,
+			},
+			want: []string{"rules.json: provider x: rules[1]: error:"},
+		},
+		{
+			name: "unescapedDot",
+			files: map[string]string{
+				"rules.json":   `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com"}}}`,
+				"aliases.json": `{"aliases":{"fixvx":{"urlPattern":"^https?:\\/\\/(?:[a-z0-9-]+\\.)*?fixvx.com","targetRuleName":"x"}}}`,
+			},
+			want: []string{`aliases.json: alias fixvx: urlPattern: warning: unescaped '.' in "fixvx.com"`},
+		},
+		{
+			name: "duplicateKey",
+			files: map[string]string{
+				"rules.json": `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com"},"x":{"urlPattern":"^https?:\\/\\/y\\.com"}}}`,
+			},
+			want: []string{"rules.json: provider x: warning: defined more than once"},
+		},
+		{
+			name: "unreachableAlias",
+			files: map[string]string{
+				"rules.json":   `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com"}}}`,
+				"aliases.json": `{"aliases":{"a":{"urlPattern":"^https?:\\/\\/a\\.com","targetRuleName":"b"},"b":{"urlPattern":"^https?:\\/\\/b\\.com","targetRuleName":"a"},"c":{"urlPattern":"^https?:\\/\\/c\\.com","targetRuleName":"gone"}}}`,
+			},
+			want: []string{"Skipping alias a: alias cycle a -> b -> a", "Skipping alias b: alias cycle b -> a -> b", "Skipping target gone of alias c"},
+		},
+		{
+			name: "backtracking",
+			files: map[string]string{
+				"rules.json": `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com","rawRules":["\\/(?:[a-z]+)+$"]}}}`,
+			},
+			want: []string{"rules.json: provider x: rawRules[0]: warning: repeated group"},
+		},
+		{
+			name: "extendsNothing",
+			files: map[string]string{
+				"rules.json":  `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com"}}}`,
+				"custom.json": `{"providers":{"y":{"rules":["t"]}}}`,
+			},
+			want: []string{"custom.json: provider y: warning: no urlPattern"},
+		},
+		{
+			name: "replaceWithoutUrlPattern",
+			files: map[string]string{
+				"rules.json":  `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com"}}}`,
+				"custom.json": `{"providers":{"x":{"replace":true,"rules":["t"]}}}`,
+			},
+			want: []string{"custom.json: provider x: replace: error: replace without a urlPattern"},
+		},
+		{
+			name: "removeNothing",
+			files: map[string]string{
+				"rules.json":  `{"providers":{"x":{"urlPattern":"^https?:\\/\\/x\\.com","rules":["t","s"]}}}`,
+				"custom.json": `{"providers":{"x":{"remove":{"rules":["s","u"],"exceptions":["t"]}}}}`,
+			},
+			want: []string{"custom.json: provider x: remove.rules[1]: warning: no earlier source adds \"u\"", "custom.json: provider x: remove.exceptions[0]: warning"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirTemp(t)
+			for name, content := range tt.files {
+				if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+			sources := []string{"rules.json", "custom.json", "aliases.json"}
+			var got []string
+			for _, issue := range Validate(sources) {
+				if strings.Contains(issue.Message, "skipped: open") {
+					continue // Files this case doesn't need
+				}
+				got = append(got, issue.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Validate() = %q, want %d issues", got, len(tt.want))
+			}
+			for i := range got {
+				if !strings.Contains(got[i], tt.want[i]) {
+					t.Errorf("Validate()[%d] = %q, want it to contain %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRemote(t *testing.T) {
+	requests := 0
+	online := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(testOnlineRules))
+	}))
+	defer online.Close()
+	chdirTemp(t)
+
+	if issues := Validate([]string{online.URL}); len(issues) != 0 {
+		t.Errorf("Validate() = %v, want no issues", issues)
+	}
+	if requests != 1 {
+		t.Errorf("Validate() made %d requests, want 1", requests)
+	}
+	for _, file := range []string{ONLINE_RULES_FILE, rulesMetaFile(ONLINE_RULES_FILE)} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Validate() wrote %s, want the cache left alone", file)
+		}
+	}
+}
+
+func TestHasNestedQuantifier(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{`(a+)+`, true},
+		{`(?:\w*)*b`, true},
+		{`(?:x|\d+){2,}`, true},
+		{`^https?:\/\/(?:[a-z0-9-]+\.)*?amazon(?:\.[a-z]{2,}){1,}`, false},
+		{`(a+){2}`, false},
+		{`[(a+)+]`, false},
+		{`\(a+\)+`, false},
+		{`(?<name>a*)+`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := hasNestedQuantifier(tt.pattern); got != tt.want {
+				t.Errorf("hasNestedQuantifier() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
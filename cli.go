@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/joho/godotenv"
+)
+
+// cleanResult is what the clean subcommand reports for every input with -json
+type cleanResult struct {
+	Input     string           `json:"input"`
+	Output    string           `json:"output"`
+	Reply     string           `json:"reply"`
+	Urls      []cleanResultUrl `json:"urls"`
+	Cleaned   int              `json:"cleaned"`
+	Redirects int              `json:"redirects"`
+	Masks     int              `json:"masks"`
+	Blocked   int              `json:"blocked"`
+	UrlOnly   bool             `json:"urlOnly"`
+}
+
+// cleanResultUrl mirrors processedUrl field by field
+type cleanResultUrl struct {
+	Raw        string `json:"raw"`
+	Processed  string `json:"processed"`
+	IsSpoiler  bool   `json:"spoiler"`
+	IsRedirect bool   `json:"redirect"`
+	IsBlocked  bool   `json:"blocked"`
+	Mask       string `json:"mask,omitempty"`
+	IsSafe     bool   `json:"safe"`
+}
+
+// stringsFlag collects a flag given more than once
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// runClean is the clean subcommand: clean [flags] [text...]
+// Every argument and every -f file is one message, without either stdin is read.
+func runClean(args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	var files stringsFlag
+	fs.Var(&files, "f", "read a message from `file`, can be repeated")
+	jsonOutput := fs.Bool("json", false, "print one JSON result per message")
+	reply := fs.Bool("reply", false, "print what the bot would reply instead of the cleaned text")
+	lines := fs.Bool("lines", false, "treat every line of the input as its own message")
+	sources := fs.String("sources", "", "comma separated rule sources, defaults to RULE_SOURCES")
+	offline := fs.Bool("offline", false, "never download rules, use the cache or the bundled rules")
+	verbose := fs.Bool("v", false, "log what is being loaded and cleaned")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s clean [flags] [text...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	data, err := loadCliRules(*sources, *offline, *verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load rules:", err)
+		return 1
+	}
+
+	inputs := fs.Args()
+	for _, name := range files {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		inputs = append(inputs, string(content))
+	}
+	if len(inputs) == 0 {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		inputs = append(inputs, string(content))
+	}
+	if *lines {
+		inputs = splitLines(inputs)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	for _, input := range inputs {
+		result, err := CleanText(input, data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to clean:", err)
+			return 1
+		}
+		switch {
+		case *jsonOutput:
+			enc.Encode(result)
+		case *reply:
+			fmt.Fprintln(out, result.Reply)
+		default:
+			fmt.Fprint(out, result.Output)
+			if !strings.HasSuffix(result.Output, "\n") {
+				fmt.Fprintln(out)
+			}
+		}
+	}
+	return 0
+}
+
+// runExplain is the explain subcommand: explain [flags] url...
+// Every url is cleaned on its own and every rule involved is listed.
+func runExplain(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "print one JSON result per url, like the HTTP API's /explain")
+	sources := fs.String("sources", "", "comma separated rule sources, defaults to RULE_SOURCES")
+	offline := fs.Bool("offline", false, "never download rules, use the cache or the bundled rules")
+	verbose := fs.Bool("v", false, "log what is being loaded")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s explain [flags] url...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	data, err := loadCliRules(*sources, *offline, *verbose)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load rules:", err)
+		return 1
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	for _, url := range fs.Args() {
+		result := explainUrl(url, data)
+		if *jsonOutput {
+			enc.Encode(result)
+			continue
+		}
+		fmt.Fprintln(out, result.Url)
+		for _, step := range result.Steps {
+			fmt.Fprintln(out, "  "+step.String())
+		}
+		fmt.Fprintln(out, "->", result.Processed)
+		if result.IsRedirect {
+			fmt.Fprintln(out, "   redirect, may lead anywhere")
+		}
+		if result.IsBlocked {
+			fmt.Fprintln(out, "   blocked, the whole url is a tracker")
+		}
+	}
+	return 0
+}
+
+// loadCliRules loads the rules for a subcommand, sources overrides RULE_SOURCES if not empty
+func loadCliRules(sources string, offline bool, verbose bool) (*clearurls.Data, error) {
+	godotenv.Load() // Optional here, only the rule settings are needed
+	if !verbose {
+		log.SetOutput(io.Discard)
+	}
+	ruleSources := RuleSourcesFromEnv()
+	if sources != "" {
+		ruleSources = parseRuleSources(sources)
+	}
+
+	rules := newCleaner(ruleSources)
+	rules.Offline = offline
+	err := rules.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return rules.Data(), nil
+}
+
+// CleanText runs text through the same pipeline as Discord messages, Output is the text with every url replaced by its cleaned version
+func CleanText(text string, data *clearurls.Data) (cleanResult, error) {
+	urlMap, cleaned, redirects, masks, blocked, notUrlOnly, err := TryCleanString(text, data)
+	if err != nil {
+		return cleanResult{}, err
+	}
+	result := cleanResult{
+		Input:     text,
+		Urls:      make([]cleanResultUrl, 0, len(urlMap)),
+		Cleaned:   cleaned,
+		Redirects: redirects,
+		Masks:     masks,
+		Blocked:   blocked,
+		UrlOnly:   !notUrlOnly,
+	}
+	if cleaned > 0 || redirects > 0 || masks > 0 || blocked > 0 {
+		result.Reply = PrepareReply(urlMap)
+	}
+	for _, url := range urlMap {
+		result.Urls = append(result.Urls, cleanResultUrl(url))
+	}
+	result.Output = cleanContent(text, urlMap)
+	return result, nil
+}
+
+func splitLines(inputs []string) []string {
+	var lines []string
+	for _, input := range inputs {
+		for _, line := range strings.Split(strings.TrimSuffix(input, "\n"), "\n") {
+			lines = append(lines, strings.TrimSuffix(line, "\r"))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCleanText(t *testing.T) {
+	data := loadTestRules(t)
+
+	tests := []struct {
+		name       string
+		text       string
+		wantOutput string
+		wantReply  string
+		wantUrls   []cleanResultUrl
+	}{
+		{
+			name:       "nothingToClean",
+			text:       "see https://example.com/a?id=1",
+			wantOutput: "see https://example.com/a?id=1",
+			wantUrls:   []cleanResultUrl{{Raw: "https://example.com/a?id=1", Processed: "https://example.com/a?id=1"}},
+		},
+		{
+			name:       "textKept",
+			text:       "see https://example.com/a?id=1&ref=x\nand https://other.org/?utm_source=y",
+			wantOutput: "see https://example.com/a?id=1\nand https://other.org/",
+			wantReply:  "https://example.com/a?id=1\nhttps://other.org/",
+			wantUrls: []cleanResultUrl{
+				{Raw: "https://example.com/a?id=1&ref=x", Processed: "https://example.com/a?id=1"},
+				{Raw: "https://other.org/?utm_source=y", Processed: "https://other.org/"},
+			},
+		},
+		{
+			name:       "noUrls",
+			text:       "just text",
+			wantOutput: "just text",
+			wantUrls:   []cleanResultUrl{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CleanText(tt.text, data)
+			if err != nil {
+				t.Fatalf("CleanText() error = %v", err)
+			}
+			if got.Output != tt.wantOutput {
+				t.Errorf("Output = %q, want %q", got.Output, tt.wantOutput)
+			}
+			if got.Reply != tt.wantReply {
+				t.Errorf("Reply = %q, want %q", got.Reply, tt.wantReply)
+			}
+			if !reflect.DeepEqual(got.Urls, tt.wantUrls) {
+				t.Errorf("Urls = %+v, want %+v", got.Urls, tt.wantUrls)
+			}
+		})
+	}
+}
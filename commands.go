@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/json/option"
+)
+
+// urlOption is the url option of /clean and /explain
+func urlOption(description string, localizations discord.StringLocales) *discord.StringOption {
+	return &discord.StringOption{
+		OptionName:               "url",
+		Description:              description,
+		DescriptionLocalizations: localizations,
+		Required:                 true,
+	}
+}
+
+var cleanCommand = api.CreateCommandData{
+	Name:        "clean",
+	Description: "Clean a url",
+	DescriptionLocalizations: discord.StringLocales{
+		discord.ChineseTaiwan: "清理網址",
+		discord.ChineseChina:  "清理网址",
+		discord.Japanese:      "URL をきれいにする",
+	},
+	Options: []discord.CommandOption{
+		urlOption("The url to clean", discord.StringLocales{
+			discord.ChineseTaiwan: "要清理的網址",
+			discord.ChineseChina:  "要清理的网址",
+			discord.Japanese:      "きれいにする URL",
+		}),
+	},
+}
+
+var explainCommand = api.CreateCommandData{
+	Name:        "explain",
+	Description: "Show which rules clean a url",
+	DescriptionLocalizations: discord.StringLocales{
+		discord.ChineseTaiwan: "顯示網址被哪些規則清理",
+		discord.ChineseChina:  "显示网址被哪些规则清理",
+		discord.Japanese:      "URL に適用されるルールを表示する",
+	},
+	Options: []discord.CommandOption{
+		urlOption("The url to explain", discord.StringLocales{
+			discord.ChineseTaiwan: "要解釋的網址",
+			discord.ChineseChina:  "要解释的网址",
+			discord.Japanese:      "説明する URL",
+		}),
+	},
+}
+
+var statsCommand = api.CreateCommandData{
+	Name:        "stats",
+	Description: "Show what the bot cleaned in this server and everywhere",
+	DescriptionLocalizations: discord.StringLocales{
+		discord.ChineseTaiwan: "顯示機器人在此伺服器與所有伺服器清理的數量",
+		discord.ChineseChina:  "显示机器人在此服务器与所有服务器清理的数量",
+		discord.Japanese:      "このサーバーと全体でボットがきれいにした数を表示する",
+	},
+	DefaultMemberPermissions: discord.NewPermissions(discord.PermissionManageGuild),
+	NoDMPermission:           true,
+	Options: []discord.CommandOption{
+		&discord.IntegerOption{
+			OptionName:  "days",
+			Description: "How many days of history to show, 7 by default",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "顯示最近幾天的紀錄，預設 7 天",
+				discord.ChineseChina:  "显示最近几天的记录，默认 7 天",
+				discord.Japanese:      "表示する履歴の日数、既定は 7 日",
+			},
+			Min: option.NewInt(1),
+			Max: option.NewInt(MAX_STATS_COMMAND_DAYS),
+		},
+	},
+}
+
+// DEFAULT_STATS_COMMAND_DAYS is how many days of history /stats shows by default
+const DEFAULT_STATS_COMMAND_DAYS = 7
+
+// MAX_STATS_COMMAND_DAYS is the most days of history /stats can show, the history may be shorter
+const MAX_STATS_COMMAND_DAYS = 365
+
+// STATS_COMMAND_TOP is how many providers and params /stats ranks
+const STATS_COMMAND_TOP = 5
+
+var rulesCommand = api.CreateCommandData{
+	Name:        "rules",
+	Description: "About the cleaning rules",
+	DescriptionLocalizations: discord.StringLocales{
+		discord.ChineseTaiwan: "關於清理規則",
+		discord.ChineseChina:  "关于清理规则",
+		discord.Japanese:      "ルールについて",
+	},
+	Options: []discord.CommandOption{
+		&discord.SubcommandOption{
+			OptionName:  "info",
+			Description: "Show the version, sources and size of the rules",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "顯示規則的版本、來源與數量",
+				discord.ChineseChina:  "显示规则的版本、来源与数量",
+				discord.Japanese:      "ルールのバージョン、ソース、数を表示する",
+			},
+		},
+	},
+}
+
+// respondEphemeral answers an interaction with content only the invoker sees
+func respondEphemeral(s *state.State, ev *gateway.InteractionCreateEvent, content string) {
+	err := s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
+		Type: api.MessageInteractionWithSource,
+		Data: &api.InteractionResponseData{
+			Content:         option.NewNullableString(content),
+			Flags:           discord.EphemeralMessage | discord.SuppressEmbeds,
+			AllowedMentions: mentionNone,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to respond to an interaction: %v", err)
+	}
+}
+
+// CleanUrlReply is the reply to /clean
+func CleanUrlReply(url string, data *clearurls.Data) string {
+	url = strings.TrimSpace(url)
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "❓ Not a url / 不是網址"
+	}
+	result := data.CleanUrl(url)
+
+	sb := strings.Builder{}
+	if result.Processed == url {
+		sb.WriteString("✅ Nothing to clean / 沒有需要清理的內容")
+	} else {
+		sb.WriteString(result.Processed)
+	}
+	if result.IsRedirect {
+		sb.WriteString(" ↪️ Redirect / 重導向網址，可能是任何站點")
+	}
+	if result.IsBlocked {
+		sb.WriteString(" ⛔ Tracking / 追蹤用網址，建議不要點擊")
+	}
+	return sb.String()
+}
+
+// StatsReply is the reply to /stats: the last days of history in guildID and channelID if guildID is valid,
+// then the all-time stats everywhere
+func StatsReply(stats *Stats, history *StatsHistory, guildID discord.GuildID, channelID discord.ChannelID, days int) string {
+	sb := strings.Builder{}
+	if guildID.IsValid() {
+		guild := history.Query(StatsQuery{GuildID: guildID, Days: days, Top: STATS_COMMAND_TOP})
+		channel := history.Query(StatsQuery{ChannelID: channelID, Days: days, Top: STATS_COMMAND_TOP})
+		fmt.Fprintf(&sb, "**This server since %s / 此伺服器自 %s**\n", guild.Since, guild.Since)
+		fmt.Fprintf(&sb, "Messages cleaned / 清理的訊息: %d / %d\n", guild.Totals.CleanedMessages, guild.Totals.Messages)
+		fmt.Fprintf(&sb, "URLs cleaned / 清理的網址: %d\n", guild.Totals.CleanedURLs)
+		fmt.Fprintf(&sb, "Params removed / 移除的參數: %d\n", guild.Totals.RemovedParams)
+		fmt.Fprintf(&sb, "Redirects / 重導向網址: %d\n", guild.Totals.Redirects)
+		fmt.Fprintf(&sb, "Tracking URLs / 追蹤用網址: %d\n", guild.Totals.Blocked)
+		fmt.Fprintf(&sb, "This channel / 此頻道: %d / %d messages cleaned / 則訊息已清理, %d params removed / 個參數已移除",
+			channel.Totals.CleanedMessages, channel.Totals.Messages, channel.Totals.RemovedParams)
+		writeRanking(&sb, "Top sites in this server / 此伺服器最常清理的網站", guild.TopProviders)
+		writeRanking(&sb, "Top params in this server / 此伺服器最常移除的參數", guild.TopParams)
+		sb.WriteString("\n\n")
+	}
+
+	counters := stats.Counters()
+	sb.WriteString("**All servers / 所有伺服器**\n")
+	fmt.Fprintf(&sb, "Messages cleaned / 清理的訊息: %d / %d\n", counters.CleanedMessages, counters.TotalMessages)
+	fmt.Fprintf(&sb, "URLs cleaned / 清理的網址: %d\n", counters.CleanedURLs)
+	fmt.Fprintf(&sb, "Params removed / 移除的參數: %d / %d\n", counters.CleanedParams, counters.TotalParams)
+	fmt.Fprintf(&sb, "Redirects / 重導向網址: %d\n", counters.Redirects)
+	fmt.Fprintf(&sb, "Tracking URLs / 追蹤用網址: %d", counters.Blocked)
+	return sb.String()
+}
+
+// writeRanking lists ranked under title, nothing if it is empty
+func writeRanking(sb *strings.Builder, title string, ranked []NamedCount) {
+	if len(ranked) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "\n%s:", title)
+	for i, named := range ranked {
+		fmt.Fprintf(sb, "\n%d. `%s` %d", i+1, named.Name, named.Count)
+	}
+}
+
+// RULES_INFO_HASH_LENGTH is how many hex digits of the SHA-256 of every source /rules info shows as its version
+const RULES_INFO_HASH_LENGTH = 12
+
+// RulesInfoReply is the reply to /rules info. The rules have no version of their own,
+// every source is versioned by the SHA-256 of its text.
+func RulesInfoReply(data *clearurls.Data) string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "Loaded / 載入時間: %s (<t:%d:R>)\n", data.LoadedAt.UTC().Format("2006-01-02 15:04 UTC"), data.LoadedAt.Unix())
+	fmt.Fprintf(&sb, "Providers / 提供者: %d\n", len(data.Providers))
+	fmt.Fprintf(&sb, "Aliases / 別名: %d\n", len(data.Aliases))
+	sb.WriteString("Sources and versions / 來源與版本:")
+	for _, source := range data.Sources {
+		version := source.Sha256
+		if len(version) > RULES_INFO_HASH_LENGTH {
+			version = version[:RULES_INFO_HASH_LENGTH]
+		}
+		fmt.Fprintf(&sb, "\n- <%s> (%s) `sha256:%s`", source.Location, source.Status, version)
+	}
+	return sb.String()
+}
+
+// HandleSlashCommand answers /clean, /stats, /explain and /rules
+func HandleSlashCommand(s *state.State, ev *gateway.InteractionCreateEvent, data *discord.CommandInteraction, rules *clearurls.Cleaner) {
+	switch data.Name {
+	case "clean", "explain", "rules":
+		ruleset := rules.Data()
+		if ruleset == nil {
+			respondEphemeral(s, ev, "⌛ The rules are not loaded yet / 規則尚未載入")
+			return
+		}
+		switch data.Name {
+		case "clean":
+			respondEphemeral(s, ev, CleanUrlReply(data.Options.Find("url").String(), ruleset))
+		case "explain":
+			respondEphemeral(s, ev, ExplainReply(data.Options.Find("url").String(), ruleset))
+		case "rules":
+			if len(data.Options) > 0 && data.Options[0].Name == "info" {
+				respondEphemeral(s, ev, RulesInfoReply(ruleset))
+			}
+		}
+	case "stats":
+		days := DEFAULT_STATS_COMMAND_DAYS
+		if opt := data.Options.Find("days"); opt.Name != "" {
+			if n, err := opt.IntValue(); err == nil {
+				days = int(n)
+			}
+		}
+		respondEphemeral(s, ev, StatsReply(stats, statsHistory, ev.GuildID, ev.ChannelID, days))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"discord_clear_urls/clearurls"
+)
+
+func TestCleanUrlReply(t *testing.T) {
+	data := loadTestRules(t)
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"notUrl", "example.com", "❓ Not a url / 不是網址"},
+		{"nothingToClean", "https://example.com/a", "✅ Nothing to clean / 沒有需要清理的內容"},
+		{"cleaned", " https://example.com/a?ref=x ", "https://example.com/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CleanUrlReply(tt.url, data); got != tt.want {
+				t.Errorf("CleanUrlReply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	info := RulesInfoReply(data)
+	version := fmt.Sprintf("%x", sha256.Sum256([]byte(testOnlineRules)))[:RULES_INFO_HASH_LENGTH]
+	for _, want := range []string{"Providers / 提供者: 1", "- <rules.json> (file) `sha256:" + version + "`"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("RulesInfoReply() = %v, want it to contain %v", info, want)
+		}
+	}
+}
+
+func TestStatsReply(t *testing.T) {
+	s := &Stats{StatsCounters: StatsCounters{CleanedMessages: 3, TotalMessages: 7}}
+	history := NewStatsHistory(7)
+	history.Record(1, 10, Counts{Messages: 1, CleanedMessages: 1, CleanedURLs: 1}, []clearurls.Trace{{Steps: []clearurls.Step{
+		{Kind: clearurls.STEP_REMOVED, Provider: "example", Param: "ref"},
+	}}})
+	history.Record(1, 11, Counts{Messages: 1}, nil)
+	history.Record(2, 20, Counts{Messages: 1, CleanedMessages: 1, CleanedURLs: 1}, []clearurls.Trace{{Steps: []clearurls.Step{
+		{Kind: clearurls.STEP_REMOVED, Provider: "other", Param: "utm_source"},
+	}}})
+
+	reply := StatsReply(s, history, 1, 10, 7)
+	for _, want := range []string{"Messages cleaned / 清理的訊息: 1 / 2\nURLs cleaned / 清理的網址: 1\nParams removed / 移除的參數: 1\n",
+		"**All servers / 所有伺服器**\nMessages cleaned / 清理的訊息: 3 / 7", "This channel / 此頻道: 1 / 1 messages cleaned",
+		"此伺服器最常清理的網站:\n1. `example` 1", "此伺服器最常移除的參數:\n1. `ref` 1"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("StatsReply() = %v, want it to contain %v", reply, want)
+		}
+	}
+	for _, other := range []string{"`other`", "`utm_source`"} {
+		if strings.Contains(reply, other) {
+			t.Errorf("StatsReply() = %v, want nothing of the other server like %v", reply, other)
+		}
+	}
+	if reply := StatsReply(s, history, 0, 0, 7); strings.Contains(reply, "This server") || strings.Contains(reply, "This channel") {
+		t.Errorf("StatsReply() without a guild = %v, want the global stats only", reply)
+	}
+}
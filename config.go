@@ -0,0 +1,638 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/json/option"
+)
+
+// CONFIG_FILE keeps the settings of every guild and channel
+const CONFIG_FILE = "guilds_config.json"
+
+const (
+	ACTION_REPLY    = "reply"    // Reply with the cleaned urls, URL only messages are deleted
+	ACTION_SUPPRESS = "suppress" // Only suppress the embeds of the message
+	ACTION_REPOST   = "repost"   // Delete the message and repost it cleaned through a webhook
+	ACTION_REACT    = "react"    // Only react to the message
+)
+
+var ACTIONS = []string{ACTION_REPLY, ACTION_SUPPRESS, ACTION_REPOST, ACTION_REACT}
+
+const MAX_DELETE_QUORUM = 10
+const MAX_DELETE_WINDOW = 60 // seconds
+
+// Settings is how the bot behaves in a guild or a channel, nil fields are inherited from the guild or the defaults
+type Settings struct {
+	Enabled          *bool  `json:"enabled,omitempty"`
+	Action           string `json:"action,omitempty"`
+	RedirectWarnings *bool  `json:"redirectWarnings,omitempty"`
+	MaskWarnings     *bool  `json:"maskWarnings,omitempty"`
+	// DeleteQuorum is how many people it takes to delete a reply about someone else's message
+	DeleteQuorum *int `json:"deleteQuorum,omitempty"`
+	// DeleteWindow is how many seconds the others have to vote after the first vote to delete
+	DeleteWindow *int `json:"deleteWindowSeconds,omitempty"`
+	// Exemptions of a channel add to the ones of its guild
+	ExemptRoles []discord.RoleID `json:"exemptRoles,omitempty"`
+	ExemptUsers []discord.UserID `json:"exemptUsers,omitempty"`
+}
+
+func (s Settings) isEmpty() bool {
+	return s.Enabled == nil && s.Action == "" && s.RedirectWarnings == nil && s.MaskWarnings == nil &&
+		s.DeleteQuorum == nil && s.DeleteWindow == nil && len(s.ExemptRoles) == 0 && len(s.ExemptUsers) == 0
+}
+
+// GuildConfig is the settings of a guild and the channels overriding them
+type GuildConfig struct {
+	Settings
+	Channels map[discord.ChannelID]Settings `json:"channels,omitempty"`
+}
+
+// ChannelConfig is what applies in a channel once the channel, guild and default settings are combined
+type ChannelConfig struct {
+	Enabled          bool
+	Action           string
+	RedirectWarnings bool
+	MaskWarnings     bool
+	DeleteQuorum     int
+	DeleteWindow     time.Duration
+	ExemptRoles      []discord.RoleID
+	ExemptUsers      []discord.UserID
+}
+
+// IsExempt tells if messages of the user with the roles are left alone
+func (c ChannelConfig) IsExempt(userID discord.UserID, roleIDs []discord.RoleID) bool {
+	for _, exempt := range c.ExemptUsers {
+		if exempt == userID {
+			return true
+		}
+	}
+	for _, exempt := range c.ExemptRoles {
+		for _, role := range roleIDs {
+			if exempt == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConfigStore holds the settings of every guild, changes are saved to its file right away
+type ConfigStore struct {
+	mu     sync.RWMutex
+	file   string
+	guilds map[discord.GuildID]*GuildConfig
+}
+
+var config = NewConfigStore(CONFIG_FILE)
+
+func NewConfigStore(file string) *ConfigStore {
+	return &ConfigStore{file: file, guilds: make(map[discord.GuildID]*GuildConfig)}
+}
+
+// Load reads the settings from the store's file, a missing file is no error
+func (c *ConfigStore) Load() error {
+	b, err := os.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	guilds := make(map[discord.GuildID]*GuildConfig)
+	err = json.Unmarshal(b, &guilds)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.guilds = guilds
+	return nil
+}
+
+// save writes every setting to the store's file, c.mu must be held
+func (c *ConfigStore) save() error {
+	b, err := json.MarshalIndent(c.guilds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return clearurls.WriteFileAtomic(c.file, b, 0644)
+}
+
+// Channel returns the settings applying in channelID of guildID
+func (c *ConfigStore) Channel(guildID discord.GuildID, channelID discord.ChannelID) ChannelConfig {
+	resolved := ChannelConfig{
+		Enabled:          true,
+		Action:           ACTION_REPLY,
+		RedirectWarnings: true,
+		MaskWarnings:     true,
+		DeleteQuorum:     DEFAULT_DELETE_QUORUM,
+		DeleteWindow:     DEFAULT_DELETE_WINDOW,
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return resolved
+	}
+	resolved.apply(guild.Settings)
+	if channel, ok := guild.Channels[channelID]; ok {
+		resolved.apply(channel)
+	}
+	return resolved
+}
+
+func (c *ChannelConfig) apply(s Settings) {
+	if s.Enabled != nil {
+		c.Enabled = *s.Enabled
+	}
+	if s.Action != "" {
+		c.Action = s.Action
+	}
+	if s.RedirectWarnings != nil {
+		c.RedirectWarnings = *s.RedirectWarnings
+	}
+	if s.MaskWarnings != nil {
+		c.MaskWarnings = *s.MaskWarnings
+	}
+	if s.DeleteQuorum != nil {
+		c.DeleteQuorum = *s.DeleteQuorum
+	}
+	if s.DeleteWindow != nil {
+		c.DeleteWindow = time.Duration(*s.DeleteWindow) * time.Second
+	}
+	c.ExemptRoles = append(c.ExemptRoles, s.ExemptRoles...)
+	c.ExemptUsers = append(c.ExemptUsers, s.ExemptUsers...)
+}
+
+// Settings returns the settings set on guildID, or on channelID of it if channelID is valid
+func (c *ConfigStore) Settings(guildID discord.GuildID, channelID discord.ChannelID) Settings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		return Settings{}
+	}
+	if channelID.IsValid() {
+		return guild.Channels[channelID]
+	}
+	return guild.Settings
+}
+
+// Update changes the settings of guildID, or of channelID of it if channelID is valid, and saves them
+func (c *ConfigStore) Update(guildID discord.GuildID, channelID discord.ChannelID, update func(*Settings)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	guild, ok := c.guilds[guildID]
+	if !ok {
+		guild = &GuildConfig{}
+		c.guilds[guildID] = guild
+	}
+
+	if !channelID.IsValid() {
+		update(&guild.Settings)
+	} else {
+		if guild.Channels == nil {
+			guild.Channels = make(map[discord.ChannelID]Settings)
+		}
+		settings := guild.Channels[channelID]
+		update(&settings)
+		guild.Channels[channelID] = settings
+		if settings.isEmpty() {
+			delete(guild.Channels, channelID)
+		}
+	}
+	if guild.Settings.isEmpty() && len(guild.Channels) == 0 {
+		delete(c.guilds, guildID)
+	}
+	return c.save()
+}
+
+// describeSettings lists the settings set, for /config show
+func describeSettings(s Settings) string {
+	var lines []string
+	if s.Enabled != nil {
+		lines = append(lines, fmt.Sprintf("enabled: %v", *s.Enabled))
+	}
+	if s.Action != "" {
+		lines = append(lines, "action: "+s.Action)
+	}
+	if s.RedirectWarnings != nil {
+		lines = append(lines, fmt.Sprintf("redirect-warnings: %v", *s.RedirectWarnings))
+	}
+	if s.MaskWarnings != nil {
+		lines = append(lines, fmt.Sprintf("mask-warnings: %v", *s.MaskWarnings))
+	}
+	if s.DeleteQuorum != nil {
+		lines = append(lines, fmt.Sprintf("delete-votes quorum: %d", *s.DeleteQuorum))
+	}
+	if s.DeleteWindow != nil {
+		lines = append(lines, fmt.Sprintf("delete-votes window: %ds", *s.DeleteWindow))
+	}
+	for _, role := range s.ExemptRoles {
+		lines = append(lines, "exempt: "+role.Mention())
+	}
+	for _, user := range s.ExemptUsers {
+		lines = append(lines, "exempt: "+user.Mention())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// configCommand is /config, Discord only offers it to members who can manage the server
+var configCommand = api.CreateCommandData{
+	Name:        "config",
+	Description: "Configure the bot in this server",
+	DescriptionLocalizations: discord.StringLocales{
+		discord.ChineseTaiwan: "設定機器人在此伺服器的行為",
+		discord.ChineseChina:  "设置机器人在此服务器的行为",
+		discord.Japanese:      "このサーバーでのボットの動作を設定する",
+	},
+	DefaultMemberPermissions: discord.NewPermissions(discord.PermissionManageGuild),
+	NoDMPermission:           true,
+	Options: []discord.CommandOption{
+		&discord.SubcommandOption{
+			OptionName:  "show",
+			Description: "Show the settings",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "顯示設定",
+				discord.ChineseChina:  "显示设置",
+				discord.Japanese:      "設定を表示する",
+			},
+			Options: []discord.CommandOptionValue{configChannelOption()},
+		},
+		&discord.SubcommandOption{
+			OptionName:  "enabled",
+			Description: "Turn cleaning on or off",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "開關網址清理",
+				discord.ChineseChina:  "开关网址清理",
+				discord.Japanese:      "URL のクリーニングをオンまたはオフにする",
+			},
+			Options: []discord.CommandOptionValue{configValueOption(), configChannelOption()},
+		},
+		&discord.SubcommandOption{
+			OptionName:  "action",
+			Description: "What to do with messages to clean",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "如何處理需要清理的訊息",
+				discord.ChineseChina:  "如何处理需要清理的消息",
+				discord.Japanese:      "きれいにするメッセージの扱い方",
+			},
+			Options: []discord.CommandOptionValue{
+				&discord.StringOption{
+					OptionName:  "mode",
+					Description: "The action",
+					DescriptionLocalizations: discord.StringLocales{
+						discord.ChineseTaiwan: "動作",
+						discord.ChineseChina:  "动作",
+						discord.Japanese:      "動作",
+					},
+					Required: true,
+					Choices: []discord.StringChoice{
+						{Name: "Reply", Value: ACTION_REPLY, NameLocalizations: discord.StringLocales{
+							discord.ChineseTaiwan: "回覆",
+							discord.ChineseChina:  "回复",
+							discord.Japanese:      "返信",
+						}},
+						{Name: "Suppress embeds only", Value: ACTION_SUPPRESS, NameLocalizations: discord.StringLocales{
+							discord.ChineseTaiwan: "僅隱藏嵌入",
+							discord.ChineseChina:  "仅隐藏嵌入",
+							discord.Japanese:      "埋め込みを隠すだけ",
+						}},
+						{Name: "Delete and repost", Value: ACTION_REPOST, NameLocalizations: discord.StringLocales{
+							discord.ChineseTaiwan: "刪除並重新發送",
+							discord.ChineseChina:  "删除并重新发送",
+							discord.Japanese:      "削除して再投稿",
+						}},
+						{Name: "React only", Value: ACTION_REACT, NameLocalizations: discord.StringLocales{
+							discord.ChineseTaiwan: "僅加上反應",
+							discord.ChineseChina:  "仅添加反应",
+							discord.Japanese:      "リアクションだけ",
+						}},
+					},
+				},
+				configChannelOption(),
+			},
+		},
+		&discord.SubcommandOption{
+			OptionName:  "redirect-warnings",
+			Description: "Warn about redirects",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "警告重導向網址",
+				discord.ChineseChina:  "警告重定向网址",
+				discord.Japanese:      "リダイレクトを警告する",
+			},
+			Options: []discord.CommandOptionValue{configValueOption(), configChannelOption()},
+		},
+		&discord.SubcommandOption{
+			OptionName:  "mask-warnings",
+			Description: "Warn about masked links",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "警告遮罩連結",
+				discord.ChineseChina:  "警告遮罩链接",
+				discord.Japanese:      "偽装リンクを警告する",
+			},
+			Options: []discord.CommandOptionValue{configValueOption(), configChannelOption()},
+		},
+		&discord.SubcommandOption{
+			OptionName:  "delete-votes",
+			Description: "How others delete a reply together",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "其他人如何一起刪除回覆",
+				discord.ChineseChina:  "其他人如何一起删除回复",
+				discord.Japanese:      "他の人が返信を一緒に削除する方法",
+			},
+			Options: []discord.CommandOptionValue{
+				&discord.IntegerOption{
+					OptionName:  "quorum",
+					Description: "People needed",
+					DescriptionLocalizations: discord.StringLocales{
+						discord.ChineseTaiwan: "需要的人數",
+						discord.ChineseChina:  "需要的人数",
+						discord.Japanese:      "必要な人数",
+					},
+					Min: option.NewInt(1),
+					Max: option.NewInt(MAX_DELETE_QUORUM),
+				},
+				&discord.IntegerOption{
+					OptionName:  "window",
+					Description: "Seconds to vote in",
+					DescriptionLocalizations: discord.StringLocales{
+						discord.ChineseTaiwan: "投票的秒數",
+						discord.ChineseChina:  "投票的秒数",
+						discord.Japanese:      "投票できる秒数",
+					},
+					Min: option.NewInt(1),
+					Max: option.NewInt(MAX_DELETE_WINDOW),
+				},
+				configChannelOption(),
+			},
+		},
+		&discord.SubcommandOption{
+			OptionName:  "exempt",
+			Description: "Leave the messages of a role or user alone",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "不處理某身分組或使用者的訊息",
+				discord.ChineseChina:  "不处理某身份组或用户的消息",
+				discord.Japanese:      "ロールまたはユーザーのメッセージを対象外にする",
+			},
+			Options: []discord.CommandOptionValue{
+				&discord.RoleOption{
+					OptionName:  "role",
+					Description: "The role",
+					DescriptionLocalizations: discord.StringLocales{
+						discord.ChineseTaiwan: "身分組",
+						discord.ChineseChina:  "身份组",
+						discord.Japanese:      "ロール",
+					},
+				},
+				&discord.UserOption{
+					OptionName:  "user",
+					Description: "The user",
+					DescriptionLocalizations: discord.StringLocales{
+						discord.ChineseTaiwan: "使用者",
+						discord.ChineseChina:  "用户",
+						discord.Japanese:      "ユーザー",
+					},
+				},
+				&discord.BooleanOption{
+					OptionName:  "remove",
+					Description: "Remove the exemption instead",
+					DescriptionLocalizations: discord.StringLocales{
+						discord.ChineseTaiwan: "改為移除豁免",
+						discord.ChineseChina:  "改为移除豁免",
+						discord.Japanese:      "代わりに対象外を解除する",
+					},
+				},
+				configChannelOption(),
+			},
+		},
+		&discord.SubcommandOption{
+			OptionName:  "reset",
+			Description: "Clear the settings",
+			DescriptionLocalizations: discord.StringLocales{
+				discord.ChineseTaiwan: "清除設定",
+				discord.ChineseChina:  "清除设置",
+				discord.Japanese:      "設定を消去する",
+			},
+			Options: []discord.CommandOptionValue{configChannelOption()},
+		},
+	},
+}
+
+func configValueOption() *discord.BooleanOption {
+	return &discord.BooleanOption{
+		OptionName:  "value",
+		Description: "On or off",
+		DescriptionLocalizations: discord.StringLocales{
+			discord.ChineseTaiwan: "開或關",
+			discord.ChineseChina:  "开或关",
+			discord.Japanese:      "オンまたはオフ",
+		},
+		Required: true,
+	}
+}
+
+func configChannelOption() *discord.ChannelOption {
+	return &discord.ChannelOption{
+		OptionName:  "channel",
+		Description: "Only for this channel instead of the whole server",
+		DescriptionLocalizations: discord.StringLocales{
+			discord.ChineseTaiwan: "僅套用於此頻道而非整個伺服器",
+			discord.ChineseChina:  "仅应用于此频道而非整个服务器",
+			discord.Japanese:      "サーバー全体ではなくこのチャンネルだけに適用する",
+		},
+	}
+}
+
+// canManageGuild tells if member has the Manage Server permission in guild. It can't be overwritten per channel,
+// so only the roles of member count, whatever channel or thread /config is used in.
+func canManageGuild(guild discord.Guild, roles []discord.Role, member discord.Member) bool {
+	return discord.CalcOverrides(guild, discord.Channel{}, member, roles).Has(discord.PermissionManageGuild)
+}
+
+// HandleConfigCommand answers /config. DefaultMemberPermissions only sets who sees /config at first,
+// server admins can open it to anyone, so the permission of the member is checked again here.
+func HandleConfigCommand(s *state.State, ev *gateway.InteractionCreateEvent, data *discord.CommandInteraction) {
+	if !ev.GuildID.IsValid() || len(data.Options) == 0 {
+		return
+	}
+	reply := "❌ You need the Manage Server permission / 需要管理伺服器權限"
+	guild, err := s.Guild(ev.GuildID)
+	var roles []discord.Role
+	if err == nil {
+		roles, err = s.Roles(ev.GuildID)
+	}
+	if err != nil {
+		log.Printf("Failed to get the roles of a /config user: %v", err)
+	} else if ev.Member != nil && canManageGuild(*guild, roles, *ev.Member) {
+		reply = RunConfigCommand(config, ev.GuildID, data.Options[0])
+	}
+	err = s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
+		Type: api.MessageInteractionWithSource,
+		Data: &api.InteractionResponseData{
+			Content:         option.NewNullableString(reply),
+			Flags:           discord.EphemeralMessage,
+			AllowedMentions: mentionNone,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to respond to /config: %v", err)
+	}
+}
+
+// RunConfigCommand applies a /config subcommand to the settings of guildID and returns the reply
+func RunConfigCommand(store *ConfigStore, guildID discord.GuildID, sub discord.CommandInteractionOption) string {
+	var channelID discord.ChannelID
+	if opt := sub.Options.Find("channel"); opt.Name != "" {
+		id, err := opt.SnowflakeValue()
+		if err != nil {
+			return "❌ Invalid channel / 無效的頻道"
+		}
+		channelID = discord.ChannelID(id)
+	}
+	value, _ := sub.Options.Find("value").BoolValue()
+
+	var update func(*Settings)
+	switch sub.Name {
+	case "show":
+		return showConfig(store, guildID, channelID)
+	case "enabled":
+		update = func(s *Settings) { s.Enabled = &value }
+	case "action":
+		mode := sub.Options.Find("mode").String()
+		if !isAction(mode) {
+			return "❌ Unknown action / 未知的動作"
+		}
+		update = func(s *Settings) { s.Action = mode }
+	case "redirect-warnings":
+		update = func(s *Settings) { s.RedirectWarnings = &value }
+	case "mask-warnings":
+		update = func(s *Settings) { s.MaskWarnings = &value }
+	case "delete-votes":
+		quorumOpt, windowOpt := sub.Options.Find("quorum"), sub.Options.Find("window")
+		if quorumOpt.Name == "" && windowOpt.Name == "" {
+			return "❌ Choose a quorum or a window / 請選擇人數或秒數"
+		}
+		quorum, _ := quorumOpt.IntValue()
+		window, _ := windowOpt.IntValue()
+		if (quorumOpt.Name != "" && (quorum < 1 || quorum > MAX_DELETE_QUORUM)) || (windowOpt.Name != "" && (window < 1 || window > MAX_DELETE_WINDOW)) {
+			return "❌ Out of range / 超出範圍"
+		}
+		update = func(s *Settings) {
+			if quorumOpt.Name != "" {
+				q := int(quorum)
+				s.DeleteQuorum = &q
+			}
+			if windowOpt.Name != "" {
+				w := int(window)
+				s.DeleteWindow = &w
+			}
+		}
+	case "exempt":
+		var roleID discord.RoleID
+		var userID discord.UserID
+		if id, err := sub.Options.Find("role").SnowflakeValue(); err == nil {
+			roleID = discord.RoleID(id)
+		}
+		if id, err := sub.Options.Find("user").SnowflakeValue(); err == nil {
+			userID = discord.UserID(id)
+		}
+		if !roleID.IsValid() && !userID.IsValid() {
+			return "❌ Choose a role or a user / 請選擇身分組或使用者"
+		}
+		remove, _ := sub.Options.Find("remove").BoolValue()
+		update = func(s *Settings) {
+			s.ExemptRoles = removeID(s.ExemptRoles, roleID)
+			s.ExemptUsers = removeID(s.ExemptUsers, userID)
+			if remove {
+				return
+			}
+			if roleID.IsValid() {
+				s.ExemptRoles = append(s.ExemptRoles, roleID)
+			}
+			if userID.IsValid() {
+				s.ExemptUsers = append(s.ExemptUsers, userID)
+			}
+		}
+	case "reset":
+		update = func(s *Settings) { *s = Settings{} }
+	default:
+		return "❌ Unknown setting / 未知的設定"
+	}
+
+	err := store.Update(guildID, channelID, update)
+	if err != nil {
+		log.Printf("Failed to save config: %v", err)
+		return "❌ Failed to save / 儲存失敗"
+	}
+	return "✅ Saved / 已儲存\n" + showConfig(store, guildID, channelID)
+}
+
+func showConfig(store *ConfigStore, guildID discord.GuildID, channelID discord.ChannelID) string {
+	sb := strings.Builder{}
+	sb.WriteString("**Server / 伺服器**\n")
+	writeSettings(&sb, store.Settings(guildID, 0))
+	if channelID.IsValid() {
+		sb.WriteString("**")
+		sb.WriteString(channelID.Mention())
+		sb.WriteString("**\n")
+		writeSettings(&sb, store.Settings(guildID, channelID))
+	}
+
+	resolved := store.Channel(guildID, channelID)
+	deleteWindow := int(resolved.DeleteWindow / time.Second)
+	sb.WriteString("**Applied / 套用中**\n")
+	writeSettings(&sb, Settings{
+		Enabled:          &resolved.Enabled,
+		Action:           resolved.Action,
+		RedirectWarnings: &resolved.RedirectWarnings,
+		MaskWarnings:     &resolved.MaskWarnings,
+		DeleteQuorum:     &resolved.DeleteQuorum,
+		DeleteWindow:     &deleteWindow,
+		ExemptRoles:      resolved.ExemptRoles,
+		ExemptUsers:      resolved.ExemptUsers,
+	})
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func writeSettings(sb *strings.Builder, s Settings) {
+	if s.isEmpty() {
+		sb.WriteString("-# Nothing set / 未設定\n")
+		return
+	}
+	sb.WriteString(describeSettings(s))
+	sb.WriteRune('\n')
+}
+
+func isAction(action string) bool {
+	for _, known := range ACTIONS {
+		if action == known {
+			return true
+		}
+	}
+	return false
+}
+
+// removeID returns ids without id
+func removeID[T comparable](ids []T, id T) []T {
+	kept := ids[:0:0]
+	for _, existing := range ids {
+		if existing != id {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
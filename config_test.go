@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/utils/json"
+)
+
+func TestConfigStore(t *testing.T) {
+	chdirTemp(t)
+	store := NewConfigStore(CONFIG_FILE)
+	off := false
+
+	defaults := ChannelConfig{Enabled: true, Action: ACTION_REPLY, RedirectWarnings: true, MaskWarnings: true,
+		DeleteQuorum: DEFAULT_DELETE_QUORUM, DeleteWindow: DEFAULT_DELETE_WINDOW}
+	if got := store.Channel(1, 10); !reflect.DeepEqual(got, defaults) {
+		t.Errorf("Channel() = %+v, want the defaults %+v", got, defaults)
+	}
+
+	mustUpdate := func(guildID discord.GuildID, channelID discord.ChannelID, update func(*Settings)) {
+		t.Helper()
+		if err := store.Update(guildID, channelID, update); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	mustUpdate(1, 0, func(s *Settings) { s.Action = ACTION_REACT; s.MaskWarnings = &off; s.ExemptRoles = []discord.RoleID{5} })
+	mustUpdate(1, 10, func(s *Settings) { s.Enabled = &off; s.ExemptUsers = []discord.UserID{6} })
+
+	want := ChannelConfig{Enabled: false, Action: ACTION_REACT, RedirectWarnings: true, MaskWarnings: false,
+		DeleteQuorum: DEFAULT_DELETE_QUORUM, DeleteWindow: DEFAULT_DELETE_WINDOW, ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{6}}
+	if got := store.Channel(1, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("Channel(1, 10) = %+v, want %+v", got, want)
+	}
+	if got := store.Channel(1, 11); got.Enabled != true || got.Action != ACTION_REACT || len(got.ExemptUsers) != 0 {
+		t.Errorf("Channel(1, 11) = %+v, want the guild settings only", got)
+	}
+	if !want.IsExempt(6, nil) || !want.IsExempt(7, []discord.RoleID{4, 5}) || want.IsExempt(7, []discord.RoleID{4}) {
+		t.Errorf("IsExempt() doesn't match the exempt users and roles")
+	}
+
+	loaded := NewConfigStore(CONFIG_FILE)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := loaded.Channel(1, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("Channel(1, 10) after Load() = %+v, want %+v", got, want)
+	}
+
+	mustUpdate(1, 10, func(s *Settings) { *s = Settings{} })
+	mustUpdate(1, 0, func(s *Settings) { *s = Settings{} })
+	if len(store.guilds) != 0 {
+		t.Errorf("guilds = %v, want empty settings dropped", store.guilds)
+	}
+}
+
+func TestRunConfigCommand(t *testing.T) {
+	chdirTemp(t)
+	store := NewConfigStore(CONFIG_FILE)
+	option := func(name string, value string) discord.CommandInteractionOption {
+		return discord.CommandInteractionOption{Name: name, Value: json.Raw(value)}
+	}
+	sub := func(name string, options ...discord.CommandInteractionOption) discord.CommandInteractionOption {
+		return discord.CommandInteractionOption{Name: name, Type: discord.SubcommandOptionType, Options: options}
+	}
+
+	tests := []struct {
+		name      string
+		sub       discord.CommandInteractionOption
+		wantReply string
+		want      Settings
+		channelID discord.ChannelID
+	}{
+		{"action", sub("action", option("mode", `"suppress"`)), "✅", Settings{Action: ACTION_SUPPRESS}, 0},
+		{"unknownAction", sub("action", option("mode", `"shout"`)), "❌", Settings{Action: ACTION_SUPPRESS}, 0},
+		{"channel", sub("redirect-warnings", option("value", "false"), option("channel", `"10"`)), "<#10>",
+			Settings{RedirectWarnings: new(bool)}, 10},
+		{"exempt", sub("exempt", option("role", `"5"`), option("user", `"6"`)), "exempt: <@&5>",
+			Settings{Action: ACTION_SUPPRESS, ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{6}}, 0},
+		{"exemptTwice", sub("exempt", option("role", `"5"`)), "✅",
+			Settings{Action: ACTION_SUPPRESS, ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{6}}, 0},
+		{"unexempt", sub("exempt", option("user", `"6"`), option("remove", "true")), "✅",
+			Settings{Action: ACTION_SUPPRESS, ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{}}, 0},
+		{"exemptNobody", sub("exempt"), "❌",
+			Settings{Action: ACTION_SUPPRESS, ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{}}, 0},
+		{"deleteVotes", sub("delete-votes", option("quorum", "3")), "✅",
+			Settings{Action: ACTION_SUPPRESS, DeleteQuorum: intPtr(3), ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{}}, 0},
+		{"deleteVotesOutOfRange", sub("delete-votes", option("window", "600")), "❌",
+			Settings{Action: ACTION_SUPPRESS, DeleteQuorum: intPtr(3), ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{}}, 0},
+		{"deleteVotesChannel", sub("delete-votes", option("window", "5"), option("channel", `"10"`)), "✅", Settings{RedirectWarnings: new(bool), DeleteWindow: intPtr(5)}, 10},
+		{"show", sub("show"), "delete-votes quorum: 3", Settings{Action: ACTION_SUPPRESS, DeleteQuorum: intPtr(3), ExemptRoles: []discord.RoleID{5}, ExemptUsers: []discord.UserID{}}, 0},
+		{"reset", sub("reset", option("channel", `"10"`)), "✅", Settings{}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := RunConfigCommand(store, 1, tt.sub)
+			if !strings.Contains(reply, tt.wantReply) {
+				t.Errorf("RunConfigCommand() = %v, want it to contain %v", reply, tt.wantReply)
+			}
+			if got := store.Settings(1, tt.channelID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Settings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanManageGuild(t *testing.T) {
+	guild := discord.Guild{ID: 1, OwnerID: 100}
+	roles := []discord.Role{
+		{ID: 1, Permissions: discord.PermissionSendMessages}, // @everyone
+		{ID: 2, Permissions: discord.PermissionManageGuild},
+		{ID: 3, Permissions: discord.PermissionAdministrator},
+	}
+	tests := []struct {
+		name   string
+		member discord.Member
+		want   bool
+	}{
+		{"owner", discord.Member{User: discord.User{ID: 100}}, true},
+		{"manager", discord.Member{User: discord.User{ID: 101}, RoleIDs: []discord.RoleID{2}}, true},
+		{"admin", discord.Member{User: discord.User{ID: 102}, RoleIDs: []discord.RoleID{3}}, true},
+		{"everyone", discord.Member{User: discord.User{ID: 103}}, false},
+		{"unknownRole", discord.Member{User: discord.User{ID: 104}, RoleIDs: []discord.RoleID{4}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canManageGuild(guild, roles, tt.member); got != tt.want {
+				t.Errorf("canManageGuild() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestApplyWarningSettings(t *testing.T) {
+	urlMap := []processedUrl{
+		{Raw: "https://a.com", IsRedirect: true},
+		{Raw: "https://b.com", Mask: "b"},
+	}
+	redirects, masks := applyWarningSettings(urlMap, ChannelConfig{RedirectWarnings: true, MaskWarnings: false}, 1, 1)
+	if redirects != 1 || masks != 0 {
+		t.Errorf("applyWarningSettings() = %v, %v, want 1, 0", redirects, masks)
+	}
+	if !urlMap[0].IsRedirect || !urlMap[1].IsSafe {
+		t.Errorf("urlMap = %+v, want the redirect kept and the mask safe", urlMap)
+	}
+	redirects, _ = applyWarningSettings(urlMap, ChannelConfig{}, 1, 0)
+	if redirects != 0 || urlMap[0].IsRedirect {
+		t.Errorf("applyWarningSettings() kept the redirect warning")
+	}
+}
@@ -2,10 +2,12 @@ module discord_clear_urls
 
 go 1.19
 
-require github.com/diamondburned/arikawa/v3 v3.4.0
+require (
+	github.com/diamondburned/arikawa/v3 v3.4.0
+	github.com/dlclark/regexp2 v1.11.4
+)
 
 require (
-	github.com/dlclark/regexp2 v1.11.4 // indirect
 	golang.org/x/crypto v0.25.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 )
@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,7 +23,6 @@ import (
 
 var stats *Stats = &Stats{}
 var mentionNone *api.AllowedMentions
-var lastDeleteRequest map[discord.MessageID]time.Time
 
 func init() {
 	mentionNone = &api.AllowedMentions{
@@ -32,27 +32,79 @@ func init() {
 		RepliedUser: new(bool),
 	}
 	*mentionNone.RepliedUser = false
-	lastDeleteRequest = make(map[discord.MessageID]time.Time)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "clean":
+			os.Exit(runClean(os.Args[2:]))
+		case "explain":
+			os.Exit(runExplain(os.Args[2:]))
+		}
+	}
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
 	loadGuildLocaleMap()
+	err = config.Load()
+	if err != nil {
+		// The defaults would turn cleaning back on and drop every exemption
+		log.Fatalf("Failed to load %s, fix or remove it: %v", CONFIG_FILE, err)
+	}
+	err = migrateRepostGuilds(config, RepostGuildsFromEnv())
+	if err != nil {
+		log.Printf("Failed to move WEBHOOK_REPOST_GUILDS into the config: %v", err)
+	}
 
-	ctx := contextWithSigterm(context.Background())
+	ctx, cancel := context.WithCancel(contextWithSigterm(context.Background()))
 
-	b, err := FetchAndLoadRules(repo)
+	rules := newCleaner(RuleSourcesFromEnv())
+	err = rules.Reload()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	go StatsWorker(ctx, stats)
+	// Loaded before any handler can track a reply
+	err = replies.Load(REPLIES_FILE)
+	if err != nil {
+		log.Printf("Failed to load replies, %s won't be saved until it is fixed or removed: %v", REPLIES_FILE, err)
+	}
+	// Loaded before any handler can record a message
+	statsHistory = NewStatsHistory(StatsRetentionFromEnv())
+	err = statsHistory.Load(STATS_HISTORY_FILE)
+	if err != nil {
+		log.Printf("Failed to load stats history, %s won't be saved until it is fixed or removed: %v", STATS_HISTORY_FILE, err)
+	}
+
+	// These workers save what they keep once ctx is done, the bot waits for them before exiting
+	savers := sync.WaitGroup{}
+	savers.Add(3)
+	go func() {
+		defer savers.Done()
+		StatsWorker(ctx, stats)
+	}()
+	go func() {
+		defer savers.Done()
+		StatsHistoryWorker(ctx, statsHistory)
+	}()
+	go func() {
+		defer savers.Done()
+		RepliesWorker(ctx, replies)
+	}()
+	go VotesWorker(ctx, deleteVotes, time.Minute)
+	go RulesWorker(ctx, rules, rulesRefreshInterval())
+	if addr := os.Getenv("HTTP_API_ADDR"); addr != "" {
+		go ApiWorker(ctx, addr, rules)
+	}
 
-	s := state.NewWithIntents("Bot "+os.Getenv("BOT_TOKEN"), gateway.IntentGuildMessages+gateway.IntentMessageContent)
+	// The Guilds intent keeps the guilds and their roles cached, /config checks the roles of its users
+	s := state.NewWithIntents("Bot "+os.Getenv("BOT_TOKEN"), gateway.IntentGuilds+gateway.IntentGuildMessages+gateway.IntentMessageContent)
 	s.AddHandler(
 		// MessageCreate is called every time a message is sent in a server the bot has access to
 		func(m *gateway.MessageCreateEvent) {
@@ -62,16 +114,46 @@ func main() {
 					log.Printf("Error when handling message: %v", err)
 				}
 			}()
-			TryCleanMessage(m, b, s)
+			TryCleanMessage(&m.Message, m.Member, rules.Data(), s)
+		},
+	)
+	s.AddHandler(
+		// MessageUpdate is called every time a message is edited, the bot's reply follows the edit
+		func(m *gateway.MessageUpdateEvent) {
+			defer func() {
+				err := recover()
+				if err != nil {
+					log.Printf("Error when handling message edit: %v", err)
+				}
+			}()
+			TryCleanEditedMessage(&m.Message, m.Member, rules.Data(), s)
 		},
 	)
 
+	s.AddHandler(func(m *gateway.MessageDeleteEvent) {
+		DeleteReplyTo(s, m.ID)
+	})
+	s.AddHandler(func(m *gateway.MessageDeleteBulkEvent) {
+		for _, id := range m.IDs {
+			DeleteReplyTo(s, id)
+		}
+	})
+
 	s.AddHandler(func(m *gateway.ReadyEvent) {
 		s.BulkOverwriteCommands(s.Ready().Application.ID, []api.CreateCommandData{
 			{
 				Name: "❌",
 				Type: discord.MessageCommand,
 			},
+			{
+				Name: CLEAN_MESSAGE_COMMAND,
+				Type: discord.MessageCommand,
+			},
+			cleanCommand,
+			explainCommand,
+			statsCommand,
+			rulesCommand,
+			configCommand,
 		})
 	})
 
@@ -79,11 +161,17 @@ func main() {
 		defer func() {
 			err := recover()
 			if err != nil {
-				log.Printf("Error when handling deletion request: %v", err)
+				log.Printf("Error when handling interaction: %v", err)
 			}
 		}()
-		data := m.Data.(*discord.CommandInteraction)
-		if data == nil {
+		if button, ok := m.Data.(*discord.ButtonInteraction); ok {
+			if button.CustomID == UNDO_BUTTON_ID {
+				HandleUndoButton(s, m)
+			}
+			return
+		}
+		data, ok := m.Data.(*discord.CommandInteraction)
+		if !ok || data == nil {
 			return
 		}
 
@@ -123,6 +211,28 @@ func main() {
 				}
 			}
 
+		case CLEAN_MESSAGE_COMMAND:
+			ruleset := rules.Data()
+			if ruleset == nil {
+				return
+			}
+			for _, message := range data.Resolved.Messages {
+				s.RespondInteraction(m.ID, m.Token, api.InteractionResponse{
+					Type: api.MessageInteractionWithSource,
+					Data: &api.InteractionResponseData{
+						Content:         option.NewNullableString(CleanMessageReply(message, ruleset)),
+						Flags:           discord.EphemeralMessage | discord.SuppressEmbeds,
+						AllowedMentions: mentionNone,
+					},
+				})
+				return
+			}
+
+		case "config":
+			HandleConfigCommand(s, m, data)
+
+		default:
+			HandleSlashCommand(s, m, data, rules)
 		}
 	})
 
@@ -133,123 +243,68 @@ func main() {
 		log.Printf("Failed to open session: %v", err)
 	}
 	defer s.Close()
+	cancel() // Connect can also return on its own, stop the workers either way
+	savers.Wait()
 }
 
+// tryDeleteByOthersDeferred counts the vote of someone other than the author to delete a reply of the bot,
+// the reply is deleted once enough people voted within the window configured for the channel
 func tryDeleteByOthersDeferred(s *state.State, ev *gateway.InteractionCreateEvent, cId discord.ChannelID, mId discord.MessageID) {
-	defer func() { // Clean up
-		maxIt := 10
-		for k, t := range lastDeleteRequest {
-			if k == mId {
-				continue // skip this subject, only clean up others
-			}
-			if time.Since(t) > time.Second*6 {
-				delete(lastDeleteRequest, k)
-			}
-			maxIt--
-			if maxIt <= 0 {
-				break
-			}
-		}
-	}()
-	waiting := false
-	lastRequestedTime, foundRequest := lastDeleteRequest[mId]
-	if foundRequest && lastDeleteRequest[mId] == time.UnixMicro(0) {
+	settings := config.Channel(ev.GuildID, cId)
+	result := deleteVotes.Cast(mId, ev.SenderID(), settings.DeleteQuorum, settings.DeleteWindow)
+	respond := func(content string) {
 		s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
 			Type: api.MessageInteractionWithSource,
 			Data: &api.InteractionResponseData{
-				Content: option.NewNullableString("Message is already deleted.\\該訊息已被刪除。"),
+				Content: option.NewNullableString(content),
 				Flags:   discord.EphemeralMessage,
 			},
 		})
-		return
 	}
-	if !foundRequest {
-		lastDeleteRequest[mId] = time.Now()
-
-		s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
-			Type: api.DeferredMessageInteractionWithSource,
-			Data: &api.InteractionResponseData{
-				Content: option.NewNullableString("Waiting for 2p...\\等待 2p...\nYou are not the OP, so you need to find someone and press this together to delete this!\n因為你不是原 PO，需要找人同時按這個才能刪除！"),
-				Flags:   discord.EphemeralMessage,
-			},
-		})
-
-		waiting = true
-		<-time.After(time.Second * 3 / 2)
-	}
-
-	lastRequestedTime, foundRequest = lastDeleteRequest[mId]
-	if !foundRequest { // Already deleted
-		if waiting {
-			s.EditInteractionResponse(ev.AppID, ev.Token, api.EditInteractionResponseData{
-				Content: option.NewNullableString("💥COMBO💥合體技發動💥\n✨٩(ˊωˋ*)و✨"),
-			})
+	notOP := fmt.Sprintf("You are not the OP, so you need %d people to press this together to delete this!\n因為你不是原 PO，需要 %d 人同時按這個才能刪除！", result.Quorum, result.Quorum)
+
+	switch result.State {
+	case VOTE_DONE:
+		respond("Message is already deleted.\\該訊息已被刪除。")
+	case VOTE_DUPLICATE:
+		respond(fmt.Sprintf("You already pressed this, %d/%d\\你已經按過了，%d/%d\n%s", result.Count, result.Quorum, result.Count, result.Quorum, notOP))
+	case VOTE_PASSED:
+		err := s.DeleteMessage(cId, mId, "Requested by others")
+		if err != nil {
+			err = s.DeleteMessage(cId, mId, "Requested by others")
 		}
-		return
-	}
-
-	if !waiting && time.Since(lastRequestedTime) < time.Second*2 {
-		if s.DeleteMessage(cId, mId, "Requested by others") != nil {
-			if s.DeleteMessage(cId, mId, "Requested by others") != nil {
-				s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
-					Type: api.MessageInteractionWithSource,
-					Data: &api.InteractionResponseData{
-						Content: option.NewNullableString("(*´･д･)? It failed... \\ 不知道為什麼失敗了..."),
-						Flags:   discord.EphemeralMessage,
-					},
-				})
-				return
-			}
+		deleteVotes.Finish(mId, err == nil)
+		if err != nil {
+			respond("(*´･д･)? It failed... \\ 不知道為什麼失敗了...")
+			return
 		}
-		lastDeleteRequest[mId] = time.UnixMicro(0)
+		respond("💥COMBO💥合體技發動💥\n✨٩(ˊωˋ*)و✨")
+	case VOTE_PENDING:
 		s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
-			Type: api.MessageInteractionWithSource,
+			Type: api.DeferredMessageInteractionWithSource,
 			Data: &api.InteractionResponseData{
-				Content: option.NewNullableString("💥COMBO💥合體技發動💥\n✨٩(ˊωˋ*)و✨"),
+				Content: option.NewNullableString(fmt.Sprintf("Waiting for %dp...\\等待 %dp...\n%s", result.Quorum, result.Quorum, notOP)),
 				Flags:   discord.EphemeralMessage,
 			},
 		})
-		return
-	}
 
-	_, err := s.EditInteractionResponse(ev.AppID, ev.Token, api.EditInteractionResponseData{
-		Content: option.NewNullableString("You are not the OP, so you need to find someone and press this together to delete this!\n因為你不是原 PO，需要找人同時按這個才能刪除！"),
-	})
-
-	if err != nil {
-		log.Printf("Error from tryDeleteByOthersDeferred: %v", err)
-	}
-}
-
-func tryDeleteByOthers(s *state.State, cId discord.ChannelID, mId discord.MessageID) bool {
-	defer func() { // Clean up
-		maxIt := 10
-		for k, t := range lastDeleteRequest {
-			if time.Since(t) > time.Second*60 {
-				delete(lastDeleteRequest, k)
-			}
-			maxIt--
-			if maxIt <= 0 {
-				break
+		content := notOP
+		select {
+		case ok := <-result.Finished:
+			if ok {
+				content = "💥COMBO💥合體技發動💥\n✨٩(ˊωˋ*)و✨"
+			} else {
+				content = "(*´･д･)? It failed... \\ 不知道為什麼失敗了..."
 			}
+		case <-time.After(time.Until(result.Deadline)):
 		}
-	}()
-	lastRequestedTime, requested := lastDeleteRequest[mId]
-	if !requested {
-		lastDeleteRequest[mId] = time.Now()
-		return false
-	}
-	if time.Since(lastRequestedTime) < time.Second*2 {
-		if s.DeleteMessage(cId, mId, "Requested by the original author") != nil {
-			s.DeleteMessage(cId, mId, "Requested by the original author")
+		_, err := s.EditInteractionResponse(ev.AppID, ev.Token, api.EditInteractionResponseData{
+			Content: option.NewNullableString(content),
+		})
+		if err != nil {
+			log.Printf("Error from tryDeleteByOthersDeferred: %v", err)
 		}
-		delete(lastDeleteRequest, mId)
-		return true
-	} else {
-		lastDeleteRequest[mId] = time.Now()
-		return false
 	}
-
 }
 
 // https://gist.github.com/matejb/87064825093c42c1e76e7175665d9a9b
@@ -270,7 +325,8 @@ func contextWithSigterm(ctx context.Context) context.Context {
 	return ctxWithCancel
 }
 
-type Stats struct {
+// StatsCounters is what Stats counts everywhere
+type StatsCounters struct {
 	CleanedMessages int
 	TotalMessages   int
 	CleanedURLs     int
@@ -278,6 +334,35 @@ type Stats struct {
 	CleanedParams   int
 	TotalParams     int
 	Redirects       int
+	Blocked         int
+}
+
+// Stats is safe for concurrent use, the counters are updated with Add and read with Counters
+type Stats struct {
+	StatsCounters
+
+	mu sync.Mutex // Guards everything above
+}
+
+// Add adds every counter of delta to the stats
+func (s *Stats) Add(delta StatsCounters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.CleanedMessages += delta.CleanedMessages
+	s.TotalMessages += delta.TotalMessages
+	s.CleanedURLs += delta.CleanedURLs
+	s.TotalURLs += delta.TotalURLs
+	s.CleanedParams += delta.CleanedParams
+	s.TotalParams += delta.TotalParams
+	s.Redirects += delta.Redirects
+	s.Blocked += delta.Blocked
+}
+
+// Counters returns a copy of the counters
+func (s *Stats) Counters() StatsCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.StatsCounters
 }
 
 func StatsWorker(ctx context.Context, stats *Stats) {
@@ -298,20 +383,23 @@ func StatsWorker(ctx context.Context, stats *Stats) {
 
 const STATS_FILE = "stats.json"
 
+// STATS_BACKUP_FILE keeps a stats file which couldn't be loaded, before the stats start over
+const STATS_BACKUP_FILE = STATS_FILE + ".bak"
+
 func LoadStats(stats *Stats) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
 
 	backup := func() {
 		data, err := os.ReadFile(STATS_FILE)
 		if err != nil {
-			fmt.Errorf("failed to read stats and failed to copy (read): %w", err)
-			*stats = Stats{}
+			log.Printf("Failed to read stats and failed to copy (read): %v", err)
 			return
 		}
 		// Write data to dst
-		err = os.WriteFile(STATS_FILE, data, 0644)
+		err = os.WriteFile(STATS_BACKUP_FILE, data, 0644)
 		if err != nil {
-			fmt.Errorf("failed to read stats and failed to copy (write): %w", err)
-			*stats = Stats{}
+			log.Printf("Failed to read stats and failed to copy (write): %v", err)
 			return
 		}
 	}
@@ -320,21 +408,38 @@ func LoadStats(stats *Stats) {
 	if err == nil {
 		err = json.Unmarshal(b, stats)
 		if err != nil {
-			fmt.Errorf("failed to unmarshal stats: %w", err)
+			log.Printf("Failed to unmarshal stats, starting over: %v", err)
 			backup()
-			*stats = Stats{}
+			stats.StatsCounters = StatsCounters{}
 			return
 		}
-	} else if err != nil && os.IsNotExist(err) {
+	} else if os.IsNotExist(err) {
 		log.Println(err)
-		*stats = Stats{}
+		stats.StatsCounters = StatsCounters{}
 	} else {
+		log.Printf("Failed to read stats, starting over: %v", err)
 		backup()
-		*stats = Stats{}
+		stats.StatsCounters = StatsCounters{}
 	}
 }
+
+// backupFile copies file to file + ".bak" before anything is saved over it, loadErr is why it couldn't be loaded.
+// The error returned is loadErr, along with the failure to back it up if any.
+func backupFile(file string, loadErr error) error {
+	b, err := os.ReadFile(file)
+	if err == nil {
+		err = os.WriteFile(file+".bak", b, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("%w, and failed to back it up: %v", loadErr, err)
+	}
+	return fmt.Errorf("%w, backed up to %s.bak", loadErr, file)
+}
+
 func SaveStats(stats *Stats) {
+	stats.mu.Lock()
 	b, err := json.Marshal(stats)
+	stats.mu.Unlock()
 	if err != nil {
 		log.Println(err)
 	}
@@ -375,7 +480,7 @@ func getGuildLocale(guildID discord.GuildID) string {
 	return ""
 }
 
-var guildLocaleMap map[int64]string
+var guildLocaleMap = make(map[int64]string)
 
 const GUILD_LOCALE_FILE = "guilds_locale.json"
 
@@ -386,16 +491,16 @@ func loadGuildLocaleMap() {
 	}
 
 	temp := make(map[string]string)
-	err = json.Unmarshal(b, temp)
+	err = json.Unmarshal(b, &temp)
 	if err != nil {
-		fmt.Errorf("failed to unmarshal guild locale map: %w", err)
+		log.Printf("Failed to unmarshal guild locale map: %v", err)
 		return
 	}
 
 	for k, v := range temp {
 		id, err := strconv.ParseInt(k, 10, 64)
 		if err != nil {
-			fmt.Errorf("skipping %s because failed to unmarshal parse guild id: %w", k, err)
+			log.Printf("Skipping %s because failed to parse guild id: %v", k, err)
 			continue
 		}
 		guildLocaleMap[id] = v
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"discord_clear_urls/clearurls"
+)
+
+// chdirTemp runs the rest of the test inside an empty temporary directory,
+// since the rule files are looked up relative to the working directory
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() error = %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir() error = %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+const testOnlineRules = `{"providers":{"globalRules":{"urlPattern":".*","rules":["utm_source"]},"example":{"urlPattern":"^https?:\\/\\/example\\.com","rules":["ref"]}}}`
+
+// TEST_RULES_FILE holds testOnlineRules in the directory of chdirTemp, see writeTestRules
+const TEST_RULES_FILE = "rules.json"
+
+// writeTestRules runs the rest of the test inside an empty temporary directory with testOnlineRules in TEST_RULES_FILE
+func writeTestRules(t *testing.T) {
+	t.Helper()
+	chdirTemp(t)
+	if err := os.WriteFile(TEST_RULES_FILE, []byte(testOnlineRules), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+// loadTestRules is writeTestRules, loaded
+func loadTestRules(t *testing.T) *clearurls.Data {
+	t.Helper()
+	writeTestRules(t)
+	data, err := clearurls.LoadRules([]string{TEST_RULES_FILE})
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+	return data
+}
+
+func TestStatsConcurrentAdd(t *testing.T) {
+	s := &Stats{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Add(StatsCounters{TotalMessages: 1, CleanedURLs: 2})
+			}
+		}()
+	}
+	wg.Wait()
+	if got := s.Counters(); got != (StatsCounters{TotalMessages: 800, CleanedURLs: 1600}) {
+		t.Errorf("Counters() = %+v, want every Add counted", got)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/state"
+)
+
+// REPLIES_FILE keeps the tracked replies across restarts
+const REPLIES_FILE = "replies.json"
+
+// REPLY_TRACKING_DURATION is how long the reply to a message follows the edits and the deletion of the message
+const REPLY_TRACKING_DURATION = time.Hour * 24
+
+// MAX_TRACKED_REPLIES bounds the memory used by tracking, the oldest replies are forgotten first
+const MAX_TRACKED_REPLIES = 10000
+
+// botReply is a reply the bot posted about a message
+type botReply struct {
+	ChannelID   discord.ChannelID
+	ID          discord.MessageID
+	ContentHash string // contentHash of the original message the reply is about, the content itself isn't kept
+	SentAt      time.Time
+}
+
+// replyTracker remembers the replies to recent messages by the ID of the original message
+type replyTracker struct {
+	mu         sync.Mutex
+	replies    map[discord.MessageID]botReply
+	changed    bool // Something to save since the last Save
+	loadFailed bool // The file couldn't be loaded, Save leaves it alone
+}
+
+var replies = newReplyTracker()
+
+func newReplyTracker() *replyTracker {
+	return &replyTracker{replies: make(map[discord.MessageID]botReply)}
+}
+
+// Track remembers reply as the reply to original, replacing any earlier one
+func (t *replyTracker) Track(original discord.MessageID, reply botReply) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.replies[original] = reply
+	t.changed = true
+	if len(t.replies) > MAX_TRACKED_REPLIES {
+		t.prune()
+	}
+}
+
+// Get returns the reply to original, if it is recent enough
+func (t *replyTracker) Get(original discord.MessageID) (botReply, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reply, ok := t.replies[original]
+	if ok && time.Since(reply.SentAt) > REPLY_TRACKING_DURATION {
+		delete(t.replies, original)
+		return botReply{}, false
+	}
+	return reply, ok
+}
+
+// Forget stops tracking the reply to original
+func (t *replyTracker) Forget(original discord.MessageID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.replies, original)
+	t.changed = true
+}
+
+// prune drops expired replies, then the oldest ones down to a tenth below MAX_TRACKED_REPLIES
+// so the next replies can be tracked without pruning again
+func (t *replyTracker) prune() {
+	for original, reply := range t.replies {
+		if time.Since(reply.SentAt) > REPLY_TRACKING_DURATION {
+			delete(t.replies, original)
+		}
+	}
+	if len(t.replies) <= MAX_TRACKED_REPLIES {
+		return
+	}
+	originals := make([]discord.MessageID, 0, len(t.replies))
+	for original := range t.replies {
+		originals = append(originals, original)
+	}
+	sort.Slice(originals, func(i, j int) bool {
+		return t.replies[originals[i]].SentAt.Before(t.replies[originals[j]].SentAt)
+	})
+	for _, original := range originals[:len(originals)-MAX_TRACKED_REPLIES*9/10] {
+		delete(t.replies, original)
+	}
+}
+
+// Load replaces the tracked replies with the ones saved in file, a missing file is no error.
+// A file which can't be loaded is backed up, see backupFile, and never saved over.
+func (t *replyTracker) Load(file string) error {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	loaded := make(map[discord.MessageID]botReply)
+	if err == nil {
+		err = json.Unmarshal(b, &loaded)
+		if err != nil {
+			err = fmt.Errorf("failed to unmarshal replies: %w", backupFile(file, err))
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.loadFailed = true
+		return err
+	}
+	t.replies = loaded
+	t.prune()
+	t.changed = false
+	return nil
+}
+
+// Save writes the tracked replies to file if they changed since the last Save
+func (t *replyTracker) Save(file string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.loadFailed {
+		return fmt.Errorf("not saving over %s, it failed to load", file)
+	}
+	if !t.changed {
+		return nil
+	}
+	b, err := json.Marshal(t.replies)
+	if err != nil {
+		return err
+	}
+	err = clearurls.WriteFileAtomic(file, b, 0600)
+	if err != nil {
+		return err
+	}
+	t.changed = false
+	return nil
+}
+
+// RepliesWorker saves the tracked replies every few minutes until ctx is done, they are loaded before the bot starts
+func RepliesWorker(ctx context.Context, tracker *replyTracker) {
+	t := time.NewTicker(time.Minute * 5)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := tracker.Save(REPLIES_FILE)
+			if err != nil {
+				log.Printf("Failed to save replies: %v", err)
+			}
+			return
+		case <-t.C:
+			err := tracker.Save(REPLIES_FILE)
+			if err != nil {
+				log.Printf("Failed to save replies: %v", err)
+			}
+		}
+	}
+}
+
+// contentTracker remembers a hash of the content of recent messages with urls, so updates of a message
+// which don't change its content, like embeds suppressed by the bot, aren't taken for edits
+type contentTracker struct {
+	mu       sync.Mutex
+	contents map[discord.MessageID]trackedContent
+}
+
+type trackedContent struct {
+	Hash   string
+	SeenAt time.Time
+}
+
+var contents = newContentTracker()
+
+func newContentTracker() *contentTracker {
+	return &contentTracker{contents: make(map[discord.MessageID]trackedContent)}
+}
+
+// contentHash is what trackers keep instead of the content of a message
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Track remembers content as the content of message
+func (t *contentTracker) Track(message discord.MessageID, content string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.contents[message] = trackedContent{Hash: contentHash(content), SeenAt: time.Now()}
+	if len(t.contents) > MAX_TRACKED_REPLIES {
+		t.prune()
+	}
+}
+
+// Changed tells if content differs from the content tracked for message, a message not tracked counts as changed
+func (t *contentTracker) Changed(message discord.MessageID, content string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked, ok := t.contents[message]
+	if !ok || time.Since(tracked.SeenAt) > REPLY_TRACKING_DURATION {
+		return true
+	}
+	return tracked.Hash != contentHash(content)
+}
+
+// prune drops expired contents, then the oldest ones down to a tenth below MAX_TRACKED_REPLIES
+func (t *contentTracker) prune() {
+	for message, tracked := range t.contents {
+		if time.Since(tracked.SeenAt) > REPLY_TRACKING_DURATION {
+			delete(t.contents, message)
+		}
+	}
+	if len(t.contents) <= MAX_TRACKED_REPLIES {
+		return
+	}
+	messages := make([]discord.MessageID, 0, len(t.contents))
+	for message := range t.contents {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return t.contents[messages[i]].SeenAt.Before(t.contents[messages[j]].SeenAt)
+	})
+	for _, message := range messages[:len(messages)-MAX_TRACKED_REPLIES*9/10] {
+		delete(t.contents, message)
+	}
+}
+
+// DeleteReplyTo deletes the bot's reply to a message which was deleted, so the reply doesn't keep its links around
+func DeleteReplyTo(s *state.State, original discord.MessageID) {
+	reply, ok := replies.Get(original)
+	if !ok {
+		return
+	}
+	replies.Forget(original)
+	err := s.DeleteMessage(reply.ChannelID, reply.ID, "Original message deleted")
+	if err != nil {
+		log.Printf("Failed to delete reply to a deleted message: %v", err)
+	}
+}
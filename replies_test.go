@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+func TestReplyTracker(t *testing.T) {
+	tracker := newReplyTracker()
+	tracker.Track(1, botReply{ID: 10, ContentHash: contentHash("a"), SentAt: time.Now()})
+	tracker.Track(2, botReply{ID: 20, ContentHash: contentHash("b"), SentAt: time.Now().Add(-REPLY_TRACKING_DURATION - time.Minute)})
+
+	if reply, ok := tracker.Get(1); !ok || reply.ID != 10 {
+		t.Errorf("Get(1) = %v, %v, want reply 10", reply, ok)
+	}
+	if reply, ok := tracker.Get(2); ok {
+		t.Errorf("Get(2) = %v, want expired", reply)
+	}
+
+	tracker.Track(1, botReply{ID: 10, ContentHash: contentHash("c"), SentAt: time.Now()})
+	if reply, _ := tracker.Get(1); reply.ContentHash != contentHash("c") {
+		t.Errorf("Get(1) content hash = %v, want the hash of c", reply.ContentHash)
+	}
+	tracker.Forget(1)
+	if _, ok := tracker.Get(1); ok {
+		t.Errorf("Get(1) after Forget(1) found a reply")
+	}
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i <= MAX_TRACKED_REPLIES; i++ {
+		tracker.Track(discord.MessageID(100+i), botReply{SentAt: start.Add(time.Duration(i) * time.Millisecond)})
+	}
+	if len(tracker.replies) != MAX_TRACKED_REPLIES*9/10 {
+		t.Errorf("tracking %d replies, want %d", len(tracker.replies), MAX_TRACKED_REPLIES*9/10)
+	}
+	if _, ok := tracker.Get(100); ok {
+		t.Errorf("the oldest reply wasn't forgotten")
+	}
+	if _, ok := tracker.Get(discord.MessageID(100 + MAX_TRACKED_REPLIES)); !ok {
+		t.Errorf("the newest reply was forgotten")
+	}
+}
+
+func TestContentTracker(t *testing.T) {
+	tracker := newContentTracker()
+	tracker.Track(1, "https://example.com/?ref=x")
+	if tracker.Changed(1, "https://example.com/?ref=x") {
+		t.Errorf("Changed() with the same content = true")
+	}
+	if !tracker.Changed(1, "https://example.com/?ref=y") {
+		t.Errorf("Changed() with new content = false")
+	}
+	if !tracker.Changed(2, "https://example.com/?ref=x") {
+		t.Errorf("Changed() of an untracked message = false")
+	}
+
+	tracker.contents[1] = trackedContent{Hash: contentHash("a"), SeenAt: time.Now().Add(-REPLY_TRACKING_DURATION - time.Minute)}
+	if !tracker.Changed(1, "a") {
+		t.Errorf("Changed() of an expired message = false")
+	}
+	for i := 0; i <= MAX_TRACKED_REPLIES; i++ {
+		tracker.Track(discord.MessageID(100+i), "a")
+	}
+	if len(tracker.contents) > MAX_TRACKED_REPLIES {
+		t.Errorf("tracking %d contents, want at most %d", len(tracker.contents), MAX_TRACKED_REPLIES)
+	}
+}
+
+func TestReplyTrackerSave(t *testing.T) {
+	chdirTemp(t)
+	tracker := newReplyTracker()
+	sentAt := time.Now().Round(0)
+	tracker.Track(1, botReply{ChannelID: 5, ID: 10, ContentHash: contentHash("a"), SentAt: sentAt})
+	tracker.Track(2, botReply{ID: 20, SentAt: sentAt.Add(-REPLY_TRACKING_DURATION - time.Minute)})
+	if err := tracker.Save(REPLIES_FILE); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded := newReplyTracker()
+	if err := loaded.Load(REPLIES_FILE); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	got, ok := loaded.replies[1]
+	if len(loaded.replies) != 1 || !ok || got.ChannelID != 5 || got.ID != 10 || got.ContentHash != contentHash("a") || !got.SentAt.Equal(sentAt) {
+		t.Errorf("Load() = %v, want only the reply to 1", loaded.replies)
+	}
+
+	if err := newReplyTracker().Load("missing.json"); err != nil {
+		t.Errorf("Load() of a missing file error = %v", err)
+	}
+	if err := os.WriteFile("broken.json", []byte("{"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	broken := newReplyTracker()
+	if err := broken.Load("broken.json"); err == nil {
+		t.Errorf("Load() of a broken file succeeded")
+	}
+	if b, err := os.ReadFile("broken.json.bak"); err != nil || string(b) != "{" {
+		t.Errorf("backup of the broken file = %q, %v, want it as it was", b, err)
+	}
+	broken.Track(3, botReply{ID: 30, SentAt: sentAt})
+	if err := broken.Save("broken.json"); err == nil {
+		t.Errorf("Save() over a file which failed to load succeeded")
+	}
+	if b, _ := os.ReadFile("broken.json"); string(b) != "{" {
+		t.Errorf("broken file = %q after Save(), want it left alone", b)
+	}
+}
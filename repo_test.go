@@ -1,7 +1,10 @@
 package main
 
 import (
+	"reflect"
 	"testing"
+
+	"discord_clear_urls/clearurls"
 )
 
 func TestFetchAndLoadJSON(t *testing.T) {
@@ -17,7 +20,7 @@ func TestFetchAndLoadJSON(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d, err := FetchAndLoadRules(tt.args.url)
+			d, err := clearurls.FetchAndLoadRules(tt.args.url)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("FetchAndLoadJSON() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -270,3 +273,15 @@ https://www.youtube.com/live/aMM3PQ312L8?si=d8UBZgrEFKJB5FUI    https://www.yout
 		})
 	}
 }
+
+func TestRuleSourcesFromEnv(t *testing.T) {
+	t.Setenv("RULE_SOURCES", "")
+	if got := RuleSourcesFromEnv(); !reflect.DeepEqual(got, DEFAULT_RULE_SOURCES) {
+		t.Errorf("RuleSourcesFromEnv() = %v, want %v", got, DEFAULT_RULE_SOURCES)
+	}
+	t.Setenv("RULE_SOURCES", " https://example.com/rules.json, ,rules.d ")
+	want := []string{"https://example.com/rules.json", "rules.d"}
+	if got := RuleSourcesFromEnv(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RuleSourcesFromEnv() = %v, want %v", got, want)
+	}
+}
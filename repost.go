@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/api/webhook"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/sendpart"
+)
+
+// REPOST_WEBHOOK_NAME is the name of the webhooks the bot creates to repost messages
+const REPOST_WEBHOOK_NAME = "ClearURLs"
+
+// MAX_REPOST_ATTACHMENTS_SIZE is the most attachment data reposted with a message, below the upload limit of every server
+const MAX_REPOST_ATTACHMENTS_SIZE = 8 * 1024 * 1024
+
+// RepostGuildsFromEnv returns the guilds listed in WEBHOOK_REPOST_GUILDS, comma separated guild IDs.
+// The action of a guild is kept in the config now, see migrateRepostGuilds.
+func RepostGuildsFromEnv() map[discord.GuildID]bool {
+	guilds := make(map[discord.GuildID]bool)
+	for _, id := range strings.Split(os.Getenv("WEBHOOK_REPOST_GUILDS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		snowflake, err := discord.ParseSnowflake(id)
+		if err != nil {
+			log.Printf("Skipping guild %s in WEBHOOK_REPOST_GUILDS: %v", id, err)
+			continue
+		}
+		guilds[discord.GuildID(snowflake)] = true
+	}
+	return guilds
+}
+
+// migrateRepostGuilds makes the guilds which have no action set in store repost, so WEBHOOK_REPOST_GUILDS
+// doesn't have to be kept next to the config
+func migrateRepostGuilds(store *ConfigStore, guilds map[discord.GuildID]bool) error {
+	for guildID := range guilds {
+		if store.Settings(guildID, 0).Action != "" {
+			continue
+		}
+		err := store.Update(guildID, 0, func(s *Settings) { s.Action = ACTION_REPOST })
+		if err != nil {
+			return err
+		}
+		log.Printf("Guild %v of WEBHOOK_REPOST_GUILDS reposts through /config now, WEBHOOK_REPOST_GUILDS can be removed.", guildID)
+	}
+	return nil
+}
+
+// RepostMessage posts message again with the urls of urlMap cleaned, through a webhook with the name and avatar of
+// the author, then deletes the original. Replies become a link to the message replied to. Nothing is deleted if the
+// message can't be reposted as a whole, e.g. when it's too long or an attachment is too large.
+func RepostMessage(s *state.State, message *discord.Message, member *discord.Member, urlMap []processedUrl) error {
+	content := cleanContent(message.Content, urlMap)
+	if message.ReferencedMessage != nil && message.Type == discord.InlinedReplyMessage {
+		content = replyLink(message.GuildID, message.ReferencedMessage) + "\n" + content
+	}
+	if utf8.RuneCountInString(content) > MAX_REPLY_LENGTH {
+		return errors.New("reposted message too long")
+	}
+
+	files, err := downloadAttachments(message.Attachments)
+	if err != nil {
+		return err
+	}
+
+	hook, err := repostWebhooks.get(s, message.ChannelID)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	data := webhook.ExecuteData{
+		Content:         content,
+		ThreadID:        discord.CommandID(hook.threadID),
+		Username:        authorName(message.Author, member),
+		AvatarURL:       authorAvatar(message.Author, member, message.GuildID),
+		Files:           files,
+		AllowedMentions: mentionNone, // Mentions already pinged with the original
+	}
+	reposted, err := hook.ExecuteAndWait(data)
+	if err != nil {
+		repostWebhooks.forget(message.ChannelID) // It may be gone, look it up again next time
+		return fmt.Errorf("execute webhook: %w", err)
+	}
+
+	err = s.DeleteMessage(message.ChannelID, message.ID, "Reposted with clean URLs")
+	if err != nil {
+		// Better no repost than the same message twice
+		if err := hook.DeleteMessage(reposted.ID); err != nil {
+			log.Printf("Failed to delete repost: %v", err)
+		}
+		return fmt.Errorf("delete original: %w", err)
+	}
+	return nil
+}
+
+// cleanContent replaces every url of urlMap in content by its cleaned version
+func cleanContent(content string, urlMap []processedUrl) string {
+	for _, url := range urlMap {
+		if url.Processed != url.Raw {
+			content = strings.ReplaceAll(content, url.Raw, url.Processed)
+		}
+	}
+	return content
+}
+
+// replyLink stands for the reply a webhook can't make
+func replyLink(guildID discord.GuildID, replied *discord.Message) string {
+	return fmt.Sprintf("-# ↪️ %s https://discord.com/channels/%s/%s/%s",
+		replied.Author.Mention(), guildID, replied.ChannelID, replied.ID)
+}
+
+func authorName(author discord.User, member *discord.Member) string {
+	if member != nil && member.Nick != "" {
+		return member.Nick
+	}
+	return author.DisplayOrUsername()
+}
+
+func authorAvatar(author discord.User, member *discord.Member, guildID discord.GuildID) discord.URL {
+	if member != nil {
+		if avatar := member.AvatarURL(guildID); avatar != "" {
+			return avatar
+		}
+	}
+	return author.AvatarURL()
+}
+
+var attachmentClient = &http.Client{Timeout: time.Second * 30}
+
+// downloadAttachments fetches the attachments to upload them again, they go away with the original message
+func downloadAttachments(attachments []discord.Attachment) ([]sendpart.File, error) {
+	total := uint64(0)
+	for _, attachment := range attachments {
+		total += attachment.Size
+	}
+	if total > MAX_REPOST_ATTACHMENTS_SIZE {
+		return nil, errors.New("attachments too large to repost")
+	}
+
+	files := make([]sendpart.File, 0, len(attachments))
+	for _, attachment := range attachments {
+		resp, err := attachmentClient.Get(attachment.URL)
+		if err != nil {
+			return nil, fmt.Errorf("download attachment %s: %w", attachment.Filename, err)
+		}
+		b, err := io.ReadAll(io.LimitReader(resp.Body, MAX_REPOST_ATTACHMENTS_SIZE+1))
+		resp.Body.Close()
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("download attachment %s: %w", attachment.Filename, err)
+		}
+		files = append(files, sendpart.File{Name: attachment.Filename, Reader: bytes.NewReader(b)})
+	}
+	return files, nil
+}
+
+// repostWebhook posts in a channel, or in a thread through the webhook of the parent channel
+type repostWebhook struct {
+	*webhook.Client
+	threadID discord.ChannelID
+}
+
+// DeleteMessage deletes a message posted through the webhook, in its thread if it posts in one
+func (h repostWebhook) DeleteMessage(messageID discord.MessageID) error {
+	url := api.EndpointWebhooks + h.ID.String() + "/" + h.Token + "/messages/" + messageID.String()
+	if h.threadID.IsValid() {
+		url += "?thread_id=" + h.threadID.String()
+	}
+	return h.FastRequest("DELETE", url)
+}
+
+// webhookCache holds the webhook used to repost in every channel
+type webhookCache struct {
+	mu    sync.Mutex
+	hooks map[discord.ChannelID]repostWebhook
+	locks map[discord.ChannelID]*sync.Mutex // One per channel, held while looking up its webhook
+}
+
+var repostWebhooks = newWebhookCache()
+
+func newWebhookCache() *webhookCache {
+	return &webhookCache{
+		hooks: make(map[discord.ChannelID]repostWebhook),
+		locks: make(map[discord.ChannelID]*sync.Mutex),
+	}
+}
+
+// get returns the webhook to post in channelID through, it's created if the bot has none there yet.
+// Only reposts in the same channel wait for each other while the webhook is looked up.
+func (c *webhookCache) get(s *state.State, channelID discord.ChannelID) (repostWebhook, error) {
+	c.mu.Lock()
+	lock, ok := c.locks[channelID]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.locks[channelID] = lock
+	}
+	c.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+	c.mu.Lock()
+	hook, ok := c.hooks[channelID]
+	c.mu.Unlock()
+	if ok {
+		return hook, nil
+	}
+
+	hook, err := findWebhook(s, channelID)
+	if err != nil {
+		return repostWebhook{}, err
+	}
+	c.mu.Lock()
+	c.hooks[channelID] = hook
+	c.mu.Unlock()
+	return hook, nil
+}
+
+// findWebhook returns the webhook of the bot to post in channelID through, created if there is none
+func findWebhook(s *state.State, channelID discord.ChannelID) (repostWebhook, error) {
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		return repostWebhook{}, err
+	}
+	hookChannelID := channelID
+	var threadID discord.ChannelID
+	switch channel.Type {
+	case discord.GuildPublicThread, discord.GuildPrivateThread, discord.GuildAnnouncementThread:
+		hookChannelID, threadID = channel.ParentID, channelID
+	}
+
+	me, err := s.Me()
+	if err != nil {
+		return repostWebhook{}, err
+	}
+	hooks, err := s.ChannelWebhooks(hookChannelID)
+	if err != nil {
+		return repostWebhook{}, err
+	}
+	var found *discord.Webhook
+	for i, hook := range hooks {
+		if hook.User != nil && hook.User.ID == me.ID && hook.Token != "" {
+			found = &hooks[i]
+			break
+		}
+	}
+	if found == nil {
+		found, err = s.CreateWebhook(hookChannelID, api.CreateWebhookData{Name: REPOST_WEBHOOK_NAME})
+		if err != nil {
+			return repostWebhook{}, err
+		}
+	}
+
+	return repostWebhook{Client: webhook.FromAPI(found.ID, found.Token, s.Client), threadID: threadID}, nil
+}
+
+// forget drops the webhook of channelID
+func (c *webhookCache) forget(channelID discord.ChannelID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hooks, channelID)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/api/webhook"
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+func TestRepostGuildsFromEnv(t *testing.T) {
+	t.Setenv("WEBHOOK_REPOST_GUILDS", " 123, ,456,nope")
+	want := map[discord.GuildID]bool{123: true, 456: true}
+	if got := RepostGuildsFromEnv(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RepostGuildsFromEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestCleanContent(t *testing.T) {
+	data := loadTestRules(t)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"text", "look https://example.com/a?ref=x !", "look https://example.com/a !"},
+		{"spoiler", "||https://example.com/a?ref=x||", "||https://example.com/a||"},
+		{"masked", "[here](<https://example.com/a?ref=x>)", "[here](<https://example.com/a>)"},
+		{"connected", "https://example.com/a?ref=xhttps://other.org/?utm_source=y", "https://example.com/ahttps://other.org/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urlMap, _, _, _, _, _, err := TryCleanString(tt.content, data)
+			if err != nil {
+				t.Fatalf("TryCleanString() error = %v", err)
+			}
+			if got := cleanContent(tt.content, urlMap); got != tt.want {
+				t.Errorf("cleanContent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorNameAndAvatar(t *testing.T) {
+	author := discord.User{ID: 1, Username: "user", DisplayName: "User", Avatar: "a"}
+	if got := authorName(author, nil); got != "User" {
+		t.Errorf("authorName() = %v, want User", got)
+	}
+	if got := authorName(author, &discord.Member{Nick: "Nick"}); got != "Nick" {
+		t.Errorf("authorName() = %v, want Nick", got)
+	}
+	if got := authorAvatar(author, &discord.Member{}, 2); got != author.AvatarURL() {
+		t.Errorf("authorAvatar() = %v, want the user's avatar", got)
+	}
+	member := &discord.Member{User: author, Avatar: "b"}
+	if got := authorAvatar(author, member, 2); got != member.AvatarURL(2) {
+		t.Errorf("authorAvatar() = %v, want the member's avatar", got)
+	}
+}
+
+func TestDownloadAttachments(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("png"))
+	}))
+	defer ts.Close()
+
+	files, err := downloadAttachments([]discord.Attachment{{Filename: "SPOILER_a.png", URL: ts.URL + "/a.png", Size: 3}})
+	if err != nil {
+		t.Fatalf("downloadAttachments() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "SPOILER_a.png" {
+		t.Fatalf("downloadAttachments() = %v, want SPOILER_a.png", files)
+	}
+	if b, _ := io.ReadAll(files[0].Reader); string(b) != "png" {
+		t.Errorf("downloaded %q, want png", b)
+	}
+
+	if _, err := downloadAttachments([]discord.Attachment{{Filename: "b.png", URL: ts.URL + "/b.png", Size: 3}}); err == nil {
+		t.Errorf("downloadAttachments() of a missing attachment succeeded")
+	}
+	if _, err := downloadAttachments([]discord.Attachment{{Filename: "c.png", URL: ts.URL + "/a.png", Size: MAX_REPOST_ATTACHMENTS_SIZE + 1}}); err == nil {
+		t.Errorf("downloadAttachments() of a too large attachment succeeded")
+	}
+}
+
+func TestRepostWebhookDeleteMessage(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.RequestURI()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	endpoint := api.EndpointWebhooks
+	api.EndpointWebhooks = ts.URL + "/webhooks/"
+	defer func() { api.EndpointWebhooks = endpoint }()
+
+	tests := []struct {
+		name     string
+		threadID discord.ChannelID
+		want     string
+	}{
+		{"channel", 0, "DELETE /webhooks/1/token/messages/7"},
+		{"thread", 5, "DELETE /webhooks/1/token/messages/7?thread_id=5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := repostWebhook{Client: webhook.FromAPI(1, "token", api.NewClient("")), threadID: tt.threadID}
+			if err := hook.DeleteMessage(7); err != nil {
+				t.Fatalf("DeleteMessage() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DeleteMessage() sent %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateRepostGuilds(t *testing.T) {
+	chdirTemp(t)
+	store := NewConfigStore(CONFIG_FILE)
+	if err := store.Update(2, 0, func(s *Settings) { s.Action = ACTION_REACT }); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := migrateRepostGuilds(store, map[discord.GuildID]bool{1: true, 2: true}); err != nil {
+		t.Fatalf("migrateRepostGuilds() error = %v", err)
+	}
+	if got := store.Channel(1, 10).Action; got != ACTION_REPOST {
+		t.Errorf("Channel() action = %v in a guild of WEBHOOK_REPOST_GUILDS, want %v", got, ACTION_REPOST)
+	}
+	if got := store.Channel(2, 20).Action; got != ACTION_REACT {
+		t.Errorf("Channel() action = %v in a guild with an action set, want it kept", got)
+	}
+	if got := store.Channel(3, 30).Action; got != ACTION_REPLY {
+		t.Errorf("Channel() action = %v in another guild, want %v", got, ACTION_REPLY)
+	}
+
+	loaded := NewConfigStore(CONFIG_FILE)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := loaded.Channel(1, 10).Action; got != ACTION_REPOST {
+		t.Errorf("Channel() action after Load() = %v, want the migration saved", got)
+	}
+}
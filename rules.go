@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"discord_clear_urls/clearurls"
+)
+
+const DEFAULT_RULES_REFRESH_INTERVAL = time.Hour * 6
+
+// repo is where the ClearURLs rules are downloaded from unless RULE_SOURCES says otherwise
+const repo string = clearurls.RULES_URL
+
+// DEFAULT_RULE_SOURCES is used when RULE_SOURCES is not set
+var DEFAULT_RULE_SOURCES = []string{repo, clearurls.CUSTOM_RULES_FILE, clearurls.ALIAS_FILE}
+
+// RuleSourcesFromEnv reads RULE_SOURCES, a comma separated list of urls, files and directories
+func RuleSourcesFromEnv() []string {
+	sources := parseRuleSources(os.Getenv("RULE_SOURCES"))
+	if len(sources) == 0 {
+		return DEFAULT_RULE_SOURCES
+	}
+	return sources
+}
+
+func parseRuleSources(list string) []string {
+	var sources []string
+	for _, source := range strings.Split(list, ",") {
+		source = strings.TrimSpace(source)
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// newCleaner makes the Cleaner the bot uses
+func newCleaner(sources []string) *clearurls.Cleaner {
+	cleaner := clearurls.NewCleaner(sources)
+	cleaner.StripReferralMarketing = os.Getenv("STRIP_REFERRAL_MARKETING") == "true"
+	return cleaner
+}
+
+// reportStats counts the events of cleaning into the global stats, only the messages the bot checks report to it
+func reportStats(event clearurls.Event) {
+	switch event {
+	case clearurls.EventUrlCleaned:
+		stats.Add(StatsCounters{CleanedURLs: 1})
+	case clearurls.EventRedirect:
+		stats.Add(StatsCounters{Redirects: 1})
+	case clearurls.EventBlocked:
+		stats.Add(StatsCounters{Blocked: 1})
+	case clearurls.EventParamChecked:
+		stats.Add(StatsCounters{TotalParams: 1})
+	case clearurls.EventParamRemoved:
+		stats.Add(StatsCounters{CleanedParams: 1})
+	}
+}
+
+// RulesWorker reloads the rules every interval until ctx is done.
+// Remote rule sources are only downloaded again once their cache is older than RULES_CACHE_MAX_AGE,
+// local files are picked up on every reload.
+func RulesWorker(ctx context.Context, rules *clearurls.Cleaner, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			err := rules.Reload()
+			if err != nil {
+				log.Printf("Failed to reload rules, keeping the previous ones: %v", err)
+				continue
+			}
+			data := rules.Data()
+			log.Printf("Reloaded rules from %d sources: %d providers", len(data.Sources), len(data.Providers))
+		}
+	}
+}
+
+// rulesRefreshInterval reads RULES_REFRESH_INTERVAL (e.g. "30m", "6h") from the environment
+func rulesRefreshInterval() time.Duration {
+	v := os.Getenv("RULES_REFRESH_INTERVAL")
+	if v == "" {
+		return DEFAULT_RULES_REFRESH_INTERVAL
+	}
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid RULES_REFRESH_INTERVAL %q, using %v", v, DEFAULT_RULES_REFRESH_INTERVAL)
+		return DEFAULT_RULES_REFRESH_INTERVAL
+	}
+	return interval
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// MAX_API_BODY is the largest /clean request accepted, far more than a Discord message can hold
+const MAX_API_BODY = 64 * 1024
+
+// MAX_API_URL is the longest url /explain accepts
+const MAX_API_URL = 8 * 1024
+
+// explainResult is the /explain response
+type explainResult struct {
+	Url        string           `json:"url"`
+	Processed  string           `json:"processed"`
+	IsRedirect bool             `json:"redirect"`
+	IsBlocked  bool             `json:"blocked"`
+	Providers  []string         `json:"providers"` // Providers whose urlPattern or aliases match, in the order they are tried
+	Steps      []clearurls.Step `json:"steps"`     // How the url was cleaned, see clearurls.Data.Explain
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// NewApiHandler serves the url cleaner over HTTP with whatever ruleset rules holds at the time of each request:
+//   - POST /clean takes text (or {"text": "..."} as JSON) and returns the same result as the clean subcommand with -json
+//   - GET /explain?url= tells which providers match a single url, what it's cleaned into and by which rules
+//   - GET /stats?guild=&channel=&provider=&days=&top= sums up the stats history, see StatsHistory.Query
+//   - GET /healthz reports if rules are loaded
+func NewApiHandler(rules *clearurls.Cleaner) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clean", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJson(w, http.StatusMethodNotAllowed, apiError{"POST only"})
+			return
+		}
+		data := rules.Data()
+		if data == nil {
+			writeJson(w, http.StatusServiceUnavailable, apiError{"rules not loaded"})
+			return
+		}
+
+		text, err := readCleanRequest(w, r)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJson(w, http.StatusRequestEntityTooLarge, apiError{"request body too large"})
+			return
+		}
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+
+		result, err := CleanText(text, data)
+		if err != nil {
+			writeJson(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, result)
+	})
+	mux.HandleFunc("/explain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJson(w, http.StatusMethodNotAllowed, apiError{"GET only"})
+			return
+		}
+		data := rules.Data()
+		if data == nil {
+			writeJson(w, http.StatusServiceUnavailable, apiError{"rules not loaded"})
+			return
+		}
+		url := r.URL.Query().Get("url")
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			writeJson(w, http.StatusBadRequest, apiError{"url must be an http or https url"})
+			return
+		}
+		if len(url) > MAX_API_URL {
+			writeJson(w, http.StatusRequestEntityTooLarge, apiError{"url too long"})
+			return
+		}
+		writeJson(w, http.StatusOK, explainUrl(url, data))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJson(w, http.StatusMethodNotAllowed, apiError{"GET only"})
+			return
+		}
+		query, err := parseStatsQuery(r.URL.Query())
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, statsHistory.Query(query))
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		data := rules.Data()
+		if data == nil {
+			writeJson(w, http.StatusServiceUnavailable, map[string]string{"status": "rules not loaded"})
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]any{
+			"status":    "ok",
+			"providers": len(data.Providers),
+			"sources":   len(data.Sources),
+			"loadedAt":  data.LoadedAt,
+		})
+	})
+	return mux
+}
+
+// readCleanRequest reads the text to clean, plain text bodies are taken as is
+func readCleanRequest(w http.ResponseWriter, r *http.Request) (string, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_API_BODY))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return string(body), nil
+	}
+	var req struct {
+		Text string `json:"text"`
+	}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return "", err
+	}
+	return req.Text, nil
+}
+
+// parseStatsQuery reads the /stats query, every value is optional
+func parseStatsQuery(values url.Values) (StatsQuery, error) {
+	query := StatsQuery{Provider: values.Get("provider")}
+	if v := values.Get("guild"); v != "" {
+		id, err := discord.ParseSnowflake(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid guild: %w", err)
+		}
+		query.GuildID = discord.GuildID(id)
+	}
+	if v := values.Get("channel"); v != "" {
+		id, err := discord.ParseSnowflake(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid channel: %w", err)
+		}
+		query.ChannelID = discord.ChannelID(id)
+	}
+	for name, dst := range map[string]*int{"days": &query.Days, "top": &query.Top} {
+		if v := values.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return query, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	return query, nil
+}
+
+func explainUrl(url string, data *clearurls.Data) explainResult {
+	result := explainResult{Url: url, Providers: data.MatchingProviders(url)}
+	if result.Providers == nil {
+		result.Providers = []string{}
+	}
+	cleaned, trace := data.Explain(url)
+	result.Processed, result.IsRedirect, result.IsBlocked = cleaned.Processed, cleaned.IsRedirect, cleaned.IsBlocked
+	result.Steps = trace.Steps
+	if result.Steps == nil {
+		result.Steps = []clearurls.Step{}
+	}
+	return result
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		log.Printf("Failed to write API response: %v", err)
+	}
+}
+
+// ApiWorker serves NewApiHandler on addr until ctx is done
+func ApiWorker(ctx context.Context, addr string, rules *clearurls.Cleaner) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           NewApiHandler(rules),
+		ReadHeaderTimeout: time.Second * 10,
+		ReadTimeout:       time.Second * 30,
+		WriteTimeout:      time.Second * 30,
+		MaxHeaderBytes:    MAX_API_URL * 2,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("HTTP API listening on %s", addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP API stopped: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"discord_clear_urls/clearurls"
+)
+
+func TestApiHandler(t *testing.T) {
+	writeTestRules(t)
+	rules := clearurls.NewCleaner([]string{TEST_RULES_FILE})
+	ts := httptest.NewServer(NewApiHandler(rules))
+	defer ts.Close()
+
+	// Nothing is served before the first load
+	resp, err := http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("healthz before load status = %v, want %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if err := rules.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantBody    string // Substring of the response
+	}{
+		{"healthz", http.MethodGet, "/healthz", "", "", http.StatusOK, `"status":"ok"`},
+		{"cleanText", http.MethodPost, "/clean", "text/plain", "see https://example.com/?id=1&ref=x", http.StatusOK,
+			`"output":"see https://example.com/?id=1"`},
+		{"cleanJson", http.MethodPost, "/clean", "application/json", `{"text":"https://example.com/?ref=x"}`, http.StatusOK,
+			`"processed":"https://example.com/"`},
+		{"cleanBadJson", http.MethodPost, "/clean", "application/json", `{"text":`, http.StatusBadRequest, `"error"`},
+		{"cleanTooLarge", http.MethodPost, "/clean", "text/plain", strings.Repeat("a", MAX_API_BODY+1), http.StatusRequestEntityTooLarge, `"error"`},
+		{"cleanGet", http.MethodGet, "/clean", "", "", http.StatusMethodNotAllowed, `"error"`},
+		{"explain", http.MethodGet, "/explain?url=" + "https%3A%2F%2Fexample.com%2F%3Fref%3Dx", "", "", http.StatusOK,
+			`"processed":"https://example.com/","redirect":false,"blocked":false,"providers":["example","globalRules"]`},
+		{"explainSteps", http.MethodGet, "/explain?url=" + "https%3A%2F%2Fexample.com%2F%3Fref%3Dx", "", "", http.StatusOK,
+			`{"kind":"removed","provider":"example","param":"ref","pattern":"ref"`},
+		{"explainNotUrl", http.MethodGet, "/explain?url=example.com", "", "", http.StatusBadRequest, `"error"`},
+		{"stats", http.MethodGet, "/stats?guild=1&days=7", "", "", http.StatusOK, `"totals":{"messages":0`},
+		{"statsBadGuild", http.MethodGet, "/stats?guild=x", "", "", http.StatusBadRequest, `"error"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			var body json.RawMessage
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("response is not JSON: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %v, want %v, body %s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// STATS_HISTORY_FILE keeps the day by day stats next to STATS_FILE
+const STATS_HISTORY_FILE = "stats_history.json"
+
+// DEFAULT_STATS_RETENTION_DAYS is how many days of history are kept unless STATS_RETENTION_DAYS says otherwise
+const DEFAULT_STATS_RETENTION_DAYS = 90
+
+// STATS_DAY_FORMAT names the day buckets, days are in UTC
+const STATS_DAY_FORMAT = "2006-01-02"
+
+// DEFAULT_STATS_TOP is how many providers and params StatsHistory.Query ranks by default
+const DEFAULT_STATS_TOP = 10
+
+// Counts is what the history counts for every guild, channel and provider
+type Counts struct {
+	Messages        int `json:"messages"` // Messages checked, not counted for providers
+	CleanedMessages int `json:"cleanedMessages"`
+	CleanedURLs     int `json:"cleanedUrls"`
+	RemovedParams   int `json:"removedParams"`
+	Redirects       int `json:"redirects"`
+	Blocked         int `json:"blocked"`
+}
+
+func (c *Counts) add(other Counts) {
+	c.Messages += other.Messages
+	c.CleanedMessages += other.CleanedMessages
+	c.CleanedURLs += other.CleanedURLs
+	c.RemovedParams += other.RemovedParams
+	c.Redirects += other.Redirects
+	c.Blocked += other.Blocked
+}
+
+// ScopeStats is a day of history of everything, a guild or a channel
+type ScopeStats struct {
+	Counts
+	Providers map[string]*Counts        `json:"providers,omitempty"`
+	Params    map[string]map[string]int `json:"params,omitempty"` // How many times every param was removed, by provider then name
+}
+
+// add counts a message into the scope, see StatsHistory.Record
+func (s *ScopeStats) add(message Counts, providers map[string]*Counts, params map[string]map[string]int) {
+	s.Counts.add(message)
+	if len(providers) > 0 && s.Providers == nil {
+		s.Providers = make(map[string]*Counts)
+	}
+	for name, counts := range providers {
+		entryOf(s.Providers, name).add(*counts)
+	}
+	if len(params) > 0 && s.Params == nil {
+		s.Params = make(map[string]map[string]int)
+	}
+	for provider, removed := range params {
+		if s.Params[provider] == nil {
+			s.Params[provider] = make(map[string]int)
+		}
+		for name, count := range removed {
+			s.Params[provider][name] += count
+		}
+	}
+}
+
+// DayStats is one day of history
+type DayStats struct {
+	Total    ScopeStats                        `json:"total"`
+	Guilds   map[discord.GuildID]*ScopeStats   `json:"guilds,omitempty"`
+	Channels map[discord.ChannelID]*ScopeStats `json:"channels,omitempty"`
+}
+
+func newDayStats() *DayStats {
+	return &DayStats{
+		Guilds:   make(map[discord.GuildID]*ScopeStats),
+		Channels: make(map[discord.ChannelID]*ScopeStats),
+	}
+}
+
+// entryOf returns the entry of key in m, added if missing
+func entryOf[K comparable, V any](m map[K]*V, key K) *V {
+	entry, ok := m[key]
+	if !ok {
+		entry = new(V)
+		m[key] = entry
+	}
+	return entry
+}
+
+// StatsHistory counts messages by day for every guild, channel and provider, safe for concurrent use.
+// Days older than its retention are dropped.
+type StatsHistory struct {
+	mu         sync.Mutex
+	days       map[string]*DayStats
+	retention  int  // Days of history to keep, today included
+	changed    bool // Something to save since the last Save
+	loadFailed bool // The file couldn't be loaded, Save leaves it alone
+	now        func() time.Time
+}
+
+var statsHistory = NewStatsHistory(DEFAULT_STATS_RETENTION_DAYS)
+
+func NewStatsHistory(retention int) *StatsHistory {
+	return &StatsHistory{days: make(map[string]*DayStats), retention: retention, now: time.Now}
+}
+
+// StatsRetentionFromEnv reads STATS_RETENTION_DAYS, how many days of history to keep
+func StatsRetentionFromEnv() int {
+	v := os.Getenv("STATS_RETENTION_DAYS")
+	if v == "" {
+		return DEFAULT_STATS_RETENTION_DAYS
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days <= 0 {
+		log.Printf("Invalid STATS_RETENTION_DAYS %q, using %v", v, DEFAULT_STATS_RETENTION_DAYS)
+		return DEFAULT_STATS_RETENTION_DAYS
+	}
+	return days
+}
+
+// Record counts a message of guildID in channelID. message holds the counts of the message itself,
+// traces tell which providers cleaned its urls and which params they removed, see clearurls.Data.CleanUrlTraced.
+// The removed params are added to the counts of the message.
+func (h *StatsHistory) Record(guildID discord.GuildID, channelID discord.ChannelID, message Counts, traces []clearurls.Trace) {
+	providers := make(map[string]*Counts)
+	params := make(map[string]map[string]int)
+	for _, trace := range traces {
+		cleanedBy := make(map[string]bool)
+		for _, step := range trace.Steps {
+			if step.Provider == "" {
+				continue
+			}
+			switch step.Kind {
+			case clearurls.STEP_REMOVED:
+				entryOf(providers, step.Provider).RemovedParams++
+				if params[step.Provider] == nil {
+					params[step.Provider] = make(map[string]int)
+				}
+				params[step.Provider][step.Param]++
+				message.RemovedParams++
+				cleanedBy[step.Provider] = true
+			case clearurls.STEP_RAW_RULE:
+				cleanedBy[step.Provider] = true
+			case clearurls.STEP_UNWRAPPED, clearurls.STEP_REDIRECT:
+				entryOf(providers, step.Provider).Redirects++
+				if step.Kind == clearurls.STEP_UNWRAPPED {
+					cleanedBy[step.Provider] = true
+				}
+			case clearurls.STEP_BLOCKED:
+				entryOf(providers, step.Provider).Blocked++
+			}
+		}
+		for provider := range cleanedBy {
+			entryOf(providers, provider).CleanedURLs++
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	day := h.today()
+	day.Total.add(message, providers, params)
+	if guildID.IsValid() {
+		entryOf(day.Guilds, guildID).add(message, providers, params)
+	}
+	if channelID.IsValid() {
+		entryOf(day.Channels, channelID).add(message, providers, params)
+	}
+	h.changed = true
+}
+
+// today returns the bucket of the current day, added if missing
+func (h *StatsHistory) today() *DayStats {
+	key := h.now().UTC().Format(STATS_DAY_FORMAT)
+	day, ok := h.days[key]
+	if !ok {
+		h.prune()
+		day = newDayStats()
+		h.days[key] = day
+	}
+	return day
+}
+
+// prune drops the days older than the retention
+func (h *StatsHistory) prune() {
+	oldest := h.since(h.retention)
+	for key := range h.days {
+		if key < oldest {
+			delete(h.days, key)
+			h.changed = true
+		}
+	}
+}
+
+// since is the first day of the last days days, today included
+func (h *StatsHistory) since(days int) string {
+	return h.now().UTC().AddDate(0, 0, 1-days).Format(STATS_DAY_FORMAT)
+}
+
+// StatsQuery selects what StatsHistory.Query sums up. ChannelID, or else GuildID, selects where,
+// neither of them selects everything. Provider narrows that down to a single provider.
+type StatsQuery struct {
+	GuildID   discord.GuildID
+	ChannelID discord.ChannelID
+	Provider  string
+	Days      int // How many days back, today included, 0 for the whole history
+	Top       int // How many providers and params to rank, 0 for DEFAULT_STATS_TOP
+}
+
+// StatsDay is the counts of a single day in a StatsReport
+type StatsDay struct {
+	Day string `json:"day"`
+	Counts
+}
+
+// NamedCount is a provider or param in the rankings of a StatsReport
+type NamedCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// StatsReport is what StatsHistory.Query found
+type StatsReport struct {
+	Since        string       `json:"since"` // The first day of the query
+	Totals       Counts       `json:"totals"`
+	Days         []StatsDay   `json:"days"`         // Every day with counts, oldest first
+	TopProviders []NamedCount `json:"topProviders"` // Providers which cleaned the most urls
+	TopParams    []NamedCount `json:"topParams"`    // Params removed the most
+}
+
+// Query sums up the history selected by q, the rankings only cover what q selects
+func (h *StatsHistory) Query(q StatsQuery) StatsReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if q.Days <= 0 || q.Days > h.retention {
+		q.Days = h.retention
+	}
+	if q.Top <= 0 {
+		q.Top = DEFAULT_STATS_TOP
+	}
+
+	report := StatsReport{Since: h.since(q.Days), Days: []StatsDay{}}
+	providers := make(map[string]int)
+	params := make(map[string]int)
+	for key, day := range h.days {
+		if key < report.Since {
+			continue
+		}
+		scope := &day.Total
+		switch {
+		case q.ChannelID.IsValid():
+			scope = day.Channels[q.ChannelID]
+		case q.GuildID.IsValid():
+			scope = day.Guilds[q.GuildID]
+		}
+		if scope == nil {
+			continue
+		}
+		counts := scope.Counts
+		if q.Provider != "" {
+			counts = Counts{}
+			if c, ok := scope.Providers[q.Provider]; ok {
+				counts = *c
+			}
+		}
+		if counts != (Counts{}) {
+			report.Days = append(report.Days, StatsDay{Day: key, Counts: counts})
+			report.Totals.add(counts)
+		}
+
+		for name, c := range scope.Providers {
+			if q.Provider == "" || name == q.Provider {
+				providers[name] += c.CleanedURLs
+			}
+		}
+		for provider, removed := range scope.Params {
+			if q.Provider != "" && provider != q.Provider {
+				continue
+			}
+			for name, count := range removed {
+				params[name] += count
+			}
+		}
+	}
+	sort.Slice(report.Days, func(i, j int) bool {
+		return report.Days[i].Day < report.Days[j].Day
+	})
+	report.TopProviders = topCounts(providers, q.Top)
+	report.TopParams = topCounts(params, q.Top)
+	return report
+}
+
+// topCounts ranks the n names with the highest counts, ties by name
+func topCounts(counts map[string]int, n int) []NamedCount {
+	ranked := make([]NamedCount, 0, len(counts))
+	for name, count := range counts {
+		if count > 0 {
+			ranked = append(ranked, NamedCount{Name: name, Count: count})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// Load replaces the history with the one saved in file, a missing file is no error.
+// A file which can't be loaded is backed up, see backupFile, and never saved over.
+func (h *StatsHistory) Load(file string) error {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	loaded := make(map[string]*DayStats)
+	if err == nil {
+		err = json.Unmarshal(b, &loaded)
+		if err != nil {
+			err = fmt.Errorf("failed to unmarshal stats history: %w", backupFile(file, err))
+		}
+	}
+	if err != nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.loadFailed = true
+		return err
+	}
+	for _, day := range loaded {
+		if day.Guilds == nil {
+			day.Guilds = make(map[discord.GuildID]*ScopeStats)
+		}
+		if day.Channels == nil {
+			day.Channels = make(map[discord.ChannelID]*ScopeStats)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.days = loaded
+	h.prune()
+	h.changed = false
+	return nil
+}
+
+// Save writes the history to file if it changed since the last Save
+func (h *StatsHistory) Save(file string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.loadFailed {
+		return fmt.Errorf("not saving over %s, it failed to load", file)
+	}
+	h.prune()
+	if !h.changed {
+		return nil
+	}
+	b, err := json.Marshal(h.days)
+	if err != nil {
+		return err
+	}
+	err = clearurls.WriteFileAtomic(file, b, 0644)
+	if err != nil {
+		return err
+	}
+	h.changed = false
+	return nil
+}
+
+// StatsHistoryWorker saves the history every few minutes until ctx is done, it is loaded before the bot starts
+func StatsHistoryWorker(ctx context.Context, history *StatsHistory) {
+	t := time.NewTicker(time.Minute * 5)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := history.Save(STATS_HISTORY_FILE)
+			if err != nil {
+				log.Printf("Failed to save stats history: %v", err)
+			}
+			return
+		case <-t.C:
+			err := history.Save(STATS_HISTORY_FILE)
+			if err != nil {
+				log.Printf("Failed to save stats history: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"discord_clear_urls/clearurls"
+)
+
+func TestStatsHistory(t *testing.T) {
+	chdirTemp(t)
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	history := NewStatsHistory(3)
+	history.now = func() time.Time { return now }
+
+	removed := func(provider string, params ...string) clearurls.Trace {
+		var trace clearurls.Trace
+		for _, param := range params {
+			trace.Steps = append(trace.Steps, clearurls.Step{Kind: clearurls.STEP_REMOVED, Provider: provider, Param: param})
+		}
+		return trace
+	}
+	cleaned := Counts{Messages: 1, CleanedMessages: 1, CleanedURLs: 1}
+
+	history.Record(1, 10, cleaned, []clearurls.Trace{removed("example", "ref", "utm_source")})
+	now = now.AddDate(0, 0, -1) // Out of order on purpose, yesterday is kept
+	history.Record(1, 11, Counts{Messages: 1}, nil)
+	now = now.AddDate(0, 0, 2)
+	history.Record(2, 20, cleaned, []clearurls.Trace{
+		removed("other", "ref"),
+		{Steps: []clearurls.Step{{Kind: clearurls.STEP_BLOCKED, Provider: "tracker"}}},
+	})
+
+	report := history.Query(StatsQuery{})
+	if report.Since != "2024-03-09" || len(report.Days) != 3 {
+		t.Errorf("Query() since %v with %d days, want 2024-03-09 and 3 days", report.Since, len(report.Days))
+	}
+	wantTotals := Counts{Messages: 3, CleanedMessages: 2, CleanedURLs: 2, RemovedParams: 3}
+	if report.Totals != wantTotals {
+		t.Errorf("Query() totals = %+v, want %+v", report.Totals, wantTotals)
+	}
+	wantParams := []NamedCount{{"ref", 2}, {"utm_source", 1}}
+	if !reflect.DeepEqual(report.TopParams, wantParams) {
+		t.Errorf("Query() top params = %v, want %v", report.TopParams, wantParams)
+	}
+	wantProviders := []NamedCount{{"example", 1}, {"other", 1}}
+	if !reflect.DeepEqual(report.TopProviders, wantProviders) {
+		t.Errorf("Query() top providers = %v, want %v", report.TopProviders, wantProviders)
+	}
+
+	tests := []struct {
+		name  string
+		query StatsQuery
+		want  Counts
+		days  int
+	}{
+		{"guild", StatsQuery{GuildID: 1}, Counts{Messages: 2, CleanedMessages: 1, CleanedURLs: 1, RemovedParams: 2}, 2},
+		{"channel", StatsQuery{GuildID: 1, ChannelID: 11}, Counts{Messages: 1}, 1},
+		{"provider", StatsQuery{Provider: "tracker"}, Counts{Blocked: 1}, 1},
+		{"providerInGuild", StatsQuery{GuildID: 1, Provider: "example"}, Counts{CleanedURLs: 1, RemovedParams: 2}, 1},
+		{"providerInOtherGuild", StatsQuery{GuildID: 2, Provider: "example"}, Counts{}, 0},
+		{"today", StatsQuery{GuildID: 1, Days: 1}, Counts{}, 0},
+		{"unknown", StatsQuery{GuildID: 3}, Counts{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := history.Query(tt.query)
+			if report.Totals != tt.want || len(report.Days) != tt.days {
+				t.Errorf("Query() = %+v over %d days, want %+v over %d days", report.Totals, len(report.Days), tt.want, tt.days)
+			}
+		})
+	}
+
+	// The rankings only cover what the query selects
+	rankings := []struct {
+		name          string
+		query         StatsQuery
+		wantProviders []NamedCount
+		wantParams    []NamedCount
+	}{
+		{"guild", StatsQuery{GuildID: 2}, []NamedCount{{"other", 1}}, []NamedCount{{"ref", 1}}},
+		{"channel", StatsQuery{ChannelID: 10}, []NamedCount{{"example", 1}}, []NamedCount{{"ref", 1}, {"utm_source", 1}}},
+		{"provider", StatsQuery{Provider: "other"}, []NamedCount{{"other", 1}}, []NamedCount{{"ref", 1}}},
+	}
+	for _, tt := range rankings {
+		t.Run(tt.name+"Rankings", func(t *testing.T) {
+			report := history.Query(tt.query)
+			if !reflect.DeepEqual(report.TopProviders, tt.wantProviders) || !reflect.DeepEqual(report.TopParams, tt.wantParams) {
+				t.Errorf("Query() top providers %v and params %v, want %v and %v", report.TopProviders, report.TopParams, tt.wantProviders, tt.wantParams)
+			}
+		})
+	}
+
+	if err := history.Save(STATS_HISTORY_FILE); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	loaded := NewStatsHistory(3)
+	loaded.now = history.now
+	if err := loaded.Load(STATS_HISTORY_FILE); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := loaded.Query(StatsQuery{}); !reflect.DeepEqual(got, history.Query(StatsQuery{})) {
+		t.Errorf("Query() after Load() = %+v, want %+v", got, history.Query(StatsQuery{}))
+	}
+
+	// Days past the retention are dropped once a new day starts
+	now = now.AddDate(0, 0, 2)
+	history.Record(1, 10, Counts{Messages: 1}, nil)
+	if len(history.days) != 2 {
+		t.Errorf("days = %d, want the 2 days within the retention", len(history.days))
+	}
+	if err := os.WriteFile(STATS_HISTORY_FILE, []byte("{"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := loaded.Load(STATS_HISTORY_FILE); err == nil {
+		t.Errorf("Load() of a broken file succeeded")
+	}
+	if b, err := os.ReadFile(STATS_HISTORY_FILE + ".bak"); err != nil || string(b) != "{" {
+		t.Errorf("backup of the broken file = %q, %v, want it as it was", b, err)
+	}
+	loaded.Record(1, 10, Counts{Messages: 1}, nil)
+	if err := loaded.Save(STATS_HISTORY_FILE); err == nil {
+		t.Errorf("Save() over a file which failed to load succeeded")
+	}
+	if b, _ := os.ReadFile(STATS_HISTORY_FILE); string(b) != "{" {
+		t.Errorf("broken file = %q after Save(), want it left alone", b)
+	}
+}
+
+func TestParseStatsQuery(t *testing.T) {
+	query, err := parseStatsQuery(url.Values{"guild": {"1"}, "channel": {"2"}, "provider": {"example"}, "days": {"7"}, "top": {"3"}})
+	if err != nil {
+		t.Fatalf("parseStatsQuery() error = %v", err)
+	}
+	want := StatsQuery{GuildID: 1, ChannelID: 2, Provider: "example", Days: 7, Top: 3}
+	if query != want {
+		t.Errorf("parseStatsQuery() = %+v, want %+v", query, want)
+	}
+	for _, values := range []url.Values{{"guild": {"x"}}, {"days": {"-1"}}, {"top": {"many"}}} {
+		if _, err := parseStatsQuery(values); err == nil {
+			t.Errorf("parseStatsQuery(%v) succeeded", values)
+		}
+	}
+}
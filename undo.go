@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/api"
+	"github.com/diamondburned/arikawa/v3/discord"
+	"github.com/diamondburned/arikawa/v3/gateway"
+	"github.com/diamondburned/arikawa/v3/state"
+	"github.com/diamondburned/arikawa/v3/utils/json/option"
+)
+
+// UNDO_BUTTON_ID is the custom ID of the button on replies to deleted messages
+const UNDO_BUTTON_ID = "undo"
+
+// UNDO_WINDOW is how long the author has to undo the deletion of their message
+const UNDO_WINDOW = time.Minute * 15
+
+// MAX_UNDO_ENTRIES bounds the memory used by deleted messages, the oldest ones are forgotten first
+const MAX_UNDO_ENTRIES = 1000
+
+// undoButton is the button added to the replies to deleted messages
+var undoButton = discord.Components(&discord.ButtonComponent{
+	Label:    "Undo / 復原",
+	Emoji:    &discord.ComponentEmoji{Name: "↩️"},
+	Style:    discord.SecondaryButtonStyle(),
+	CustomID: UNDO_BUTTON_ID,
+})
+
+// deletedMessage is a message the bot deleted after replying with its urls cleaned
+type deletedMessage struct {
+	AuthorID  discord.UserID
+	ChannelID discord.ChannelID
+	Content   string
+	// ReplyTo is the message the deleted message replied to, if the reply of the bot still points at it
+	ReplyTo   *discord.MessageReference
+	DeletedAt time.Time
+}
+
+type UndoResult int
+
+const (
+	UNDO_OK         UndoResult = iota
+	UNDO_NOT_AUTHOR            // Someone other than the author pressed the button
+	UNDO_EXPIRED               // The message is unknown or was deleted longer than UNDO_WINDOW ago
+)
+
+// undoStore keeps deleted messages by the ID of the bot's reply for UNDO_WINDOW, safe for concurrent use
+type undoStore struct {
+	mu       sync.Mutex
+	messages map[discord.MessageID]deletedMessage
+	now      func() time.Time
+}
+
+var undos = newUndoStore()
+
+func newUndoStore() *undoStore {
+	return &undoStore{messages: make(map[discord.MessageID]deletedMessage), now: time.Now}
+}
+
+// Put remembers message as deleted in favour of reply
+func (u *undoStore) Put(reply discord.MessageID, message deletedMessage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.messages[reply] = message
+	if len(u.messages) > MAX_UNDO_ENTRIES {
+		u.prune()
+	}
+}
+
+// Take returns and forgets the message deleted in favour of reply if userID is its author
+func (u *undoStore) Take(reply discord.MessageID, userID discord.UserID) (deletedMessage, UndoResult) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	message, ok := u.messages[reply]
+	if !ok {
+		return deletedMessage{}, UNDO_EXPIRED
+	}
+	if u.now().Sub(message.DeletedAt) > UNDO_WINDOW {
+		delete(u.messages, reply)
+		return deletedMessage{}, UNDO_EXPIRED
+	}
+	if message.AuthorID != userID {
+		return deletedMessage{}, UNDO_NOT_AUTHOR
+	}
+	delete(u.messages, reply)
+	return message, UNDO_OK
+}
+
+// prune drops expired messages, then the oldest ones down to a tenth below MAX_UNDO_ENTRIES
+func (u *undoStore) prune() {
+	now := u.now()
+	for reply, message := range u.messages {
+		if now.Sub(message.DeletedAt) > UNDO_WINDOW {
+			delete(u.messages, reply)
+		}
+	}
+	if len(u.messages) <= MAX_UNDO_ENTRIES {
+		return
+	}
+	replyIDs := make([]discord.MessageID, 0, len(u.messages))
+	for reply := range u.messages {
+		replyIDs = append(replyIDs, reply)
+	}
+	sort.Slice(replyIDs, func(i, j int) bool {
+		return u.messages[replyIDs[i]].DeletedAt.Before(u.messages[replyIDs[j]].DeletedAt)
+	})
+	for _, reply := range replyIDs[:len(replyIDs)-MAX_UNDO_ENTRIES*9/10] {
+		delete(u.messages, reply)
+	}
+}
+
+// HandleUndoButton reposts the original urls of a deleted message and removes the reply of the bot,
+// if the author of the message pressed the undo button in time
+func HandleUndoButton(s *state.State, ev *gateway.InteractionCreateEvent) {
+	if ev.Message == nil {
+		return
+	}
+	respond := func(content string) {
+		s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{
+			Type: api.MessageInteractionWithSource,
+			Data: &api.InteractionResponseData{
+				Content: option.NewNullableString(content),
+				Flags:   discord.EphemeralMessage,
+			},
+		})
+	}
+
+	message, result := undos.Take(ev.Message.ID, ev.SenderID())
+	switch result {
+	case UNDO_NOT_AUTHOR:
+		respond("Only the author can undo this / 只有原 PO 可以復原")
+		return
+	case UNDO_EXPIRED:
+		respond("⌛ Too late to undo / 已超過復原時限")
+		return
+	}
+
+	_, err := s.SendMessageComplex(message.ChannelID, api.SendMessageData{
+		Content:         replyContent(discord.User{ID: message.AuthorID}, nil, message.Content),
+		AllowedMentions: mentionNone,
+		Reference:       message.ReplyTo,
+		Flags:           discord.SuppressNotifications,
+	})
+	if err != nil {
+		log.Printf("Failed to repost the original urls: %v", err)
+		undos.Put(ev.Message.ID, message)
+		respond("(*´･д･)? It failed... \\ 不知道為什麼失敗了...")
+		return
+	}
+
+	s.RespondInteraction(ev.ID, ev.Token, api.InteractionResponse{Type: api.DeferredMessageUpdate})
+	err = s.DeleteMessage(ev.Message.ChannelID, ev.Message.ID, "Undone by the original author")
+	if err != nil {
+		log.Printf("Failed to delete the undone reply: %v", err)
+	}
+}
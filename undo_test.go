@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+func TestUndoStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := newUndoStore()
+	store.now = func() time.Time { return now }
+
+	message := deletedMessage{AuthorID: 5, ChannelID: 10, Content: "https://example.com/?ref=x", DeletedAt: now}
+	store.Put(1, message)
+	if _, result := store.Take(1, 6); result != UNDO_NOT_AUTHOR {
+		t.Errorf("Take() by someone else = %v, want UNDO_NOT_AUTHOR", result)
+	}
+	got, result := store.Take(1, 5)
+	if result != UNDO_OK || got != message {
+		t.Errorf("Take() = %+v, %v, want %+v, UNDO_OK", got, result, message)
+	}
+	if _, result := store.Take(1, 5); result != UNDO_EXPIRED {
+		t.Errorf("second Take() = %v, want UNDO_EXPIRED", result)
+	}
+
+	store.Put(2, message)
+	now = now.Add(UNDO_WINDOW + time.Second)
+	if _, result := store.Take(2, 5); result != UNDO_EXPIRED {
+		t.Errorf("Take() after UNDO_WINDOW = %v, want UNDO_EXPIRED", result)
+	}
+}
+
+func TestUndoStoreBounded(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := newUndoStore()
+	store.now = func() time.Time { return now }
+
+	for i := 1; i <= MAX_UNDO_ENTRIES+1; i++ {
+		store.Put(discord.MessageID(i), deletedMessage{AuthorID: 5, DeletedAt: now.Add(time.Duration(i) * time.Millisecond)})
+	}
+	if len(store.messages) > MAX_UNDO_ENTRIES {
+		t.Errorf("len(messages) = %d, want at most %d", len(store.messages), MAX_UNDO_ENTRIES)
+	}
+	if _, result := store.Take(1, 5); result != UNDO_EXPIRED {
+		t.Errorf("Take() of the oldest message = %v, want it dropped", result)
+	}
+	if _, result := store.Take(MAX_UNDO_ENTRIES+1, 5); result != UNDO_OK {
+		t.Errorf("Take() of the newest message = %v, want UNDO_OK", result)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"discord_clear_urls/clearurls"
+
+	"github.com/joho/godotenv"
+)
+
+// runValidate is the validate subcommand: validate [source...]
+// Without arguments the sources come from RULE_SOURCES like when the bot starts.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate [-strict] [source...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	godotenv.Load()           // Optional here, only RULE_SOURCES is needed
+	log.SetOutput(io.Discard) // Loading logs its progress, the issues are all that matter here
+	sources := fs.Args()
+	if len(sources) == 0 {
+		sources = RuleSourcesFromEnv()
+	}
+
+	issues := clearurls.Validate(sources)
+	errorCount, warningCount := 0, 0
+	for _, issue := range issues {
+		fmt.Println(issue)
+		if issue.Severity == clearurls.SEVERITY_ERROR {
+			errorCount++
+		} else {
+			warningCount++
+		}
+	}
+	fmt.Printf("%d errors, %d warnings in %d sources\n", errorCount, warningCount, len(sources))
+
+	if errorCount > 0 || (*strict && warningCount > 0) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+// DEFAULT_DELETE_QUORUM is how many people it takes to delete a reply of the bot about someone else's message
+const DEFAULT_DELETE_QUORUM = 2
+
+// DEFAULT_DELETE_WINDOW is how long after the first vote to delete the others have to vote too
+const DEFAULT_DELETE_WINDOW = time.Second * 2
+
+// VOTE_DONE_RETENTION is how long votes which reached their quorum are kept to tell latecomers
+const VOTE_DONE_RETENTION = time.Minute
+
+type VoteState int
+
+const (
+	VOTE_PENDING   VoteState = iota // Counted, more votes are needed
+	VOTE_PASSED                     // This vote reached the quorum, the voter must act and call Finish
+	VOTE_DUPLICATE                  // The user already voted
+	VOTE_DONE                       // The quorum was already reached
+)
+
+// VoteResult is what casting a vote did
+type VoteResult struct {
+	State  VoteState
+	Count  int // Votes counted so far
+	Quorum int
+	// Deadline is when a pending vote expires
+	Deadline time.Time
+	// Finished receives if acting on the vote succeeded once the quorum is reached, for pending votes only
+	Finished <-chan bool
+}
+
+type vote struct {
+	started  time.Time
+	window   time.Duration
+	quorum   int
+	voters   map[discord.UserID]bool
+	waiters  []chan bool
+	passed   bool
+	passedAt time.Time
+}
+
+// VoteTracker counts votes to delete messages, safe for concurrent use.
+// Votes for a message expire a window after the first one, see VotesWorker for their cleanup.
+type VoteTracker struct {
+	mu    sync.Mutex
+	votes map[discord.MessageID]*vote
+	now   func() time.Time
+}
+
+var deleteVotes = NewVoteTracker()
+
+func NewVoteTracker() *VoteTracker {
+	return &VoteTracker{votes: make(map[discord.MessageID]*vote), now: time.Now}
+}
+
+// Cast counts the vote of userID for messageID. A new vote for messageID needs quorum different users voting within window.
+func (t *VoteTracker) Cast(messageID discord.MessageID, userID discord.UserID, quorum int, window time.Duration) VoteResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	v, ok := t.votes[messageID]
+	if ok && v.expired(now) {
+		ok = false
+	}
+	if !ok {
+		v = &vote{started: now, window: window, quorum: quorum, voters: make(map[discord.UserID]bool)}
+		t.votes[messageID] = v
+	}
+
+	result := VoteResult{Count: len(v.voters), Quorum: v.quorum, Deadline: v.started.Add(v.window)}
+	switch {
+	case v.passed:
+		result.State = VOTE_DONE
+		return result
+	case v.voters[userID]:
+		result.State = VOTE_DUPLICATE
+		return result
+	}
+
+	v.voters[userID] = true
+	result.Count++
+	if result.Count >= v.quorum {
+		v.passed, v.passedAt = true, now
+		result.State = VOTE_PASSED
+		return result
+	}
+	finished := make(chan bool, 1)
+	v.waiters = append(v.waiters, finished)
+	result.State = VOTE_PENDING
+	result.Finished = finished
+	return result
+}
+
+// Finish tells the pending voters of messageID if acting on their vote succeeded.
+// On failure the votes are dropped so voting can start over.
+func (t *VoteTracker) Finish(messageID discord.MessageID, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, found := t.votes[messageID]
+	if !found || !v.passed {
+		return
+	}
+	for _, waiter := range v.waiters {
+		waiter <- ok
+	}
+	v.waiters = nil
+	if !ok {
+		delete(t.votes, messageID)
+	}
+}
+
+// Prune drops the expired votes and the passed ones older than VOTE_DONE_RETENTION
+func (t *VoteTracker) Prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for messageID, v := range t.votes {
+		if v.expired(now) || (v.passed && now.Sub(v.passedAt) > VOTE_DONE_RETENTION) {
+			delete(t.votes, messageID)
+		}
+	}
+}
+
+func (v *vote) expired(now time.Time) bool {
+	return !v.passed && now.Sub(v.started) > v.window
+}
+
+// VotesWorker prunes votes every interval until ctx is done
+func VotesWorker(ctx context.Context, tracker *VoteTracker, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			tracker.Prune()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/arikawa/v3/discord"
+)
+
+func TestVoteTracker(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := NewVoteTracker()
+	tracker.now = func() time.Time { return now }
+
+	first := tracker.Cast(1, 10, 3, time.Second*2)
+	if first.State != VOTE_PENDING || first.Count != 1 || first.Quorum != 3 || !first.Deadline.Equal(now.Add(time.Second*2)) {
+		t.Fatalf("first Cast() = %+v, want pending 1/3", first)
+	}
+	if got := tracker.Cast(1, 10, 3, time.Second*2); got.State != VOTE_DUPLICATE || got.Count != 1 {
+		t.Errorf("Cast() by the same user = %+v, want duplicate", got)
+	}
+	now = now.Add(time.Second)
+	second := tracker.Cast(1, 11, 5, time.Hour) // The quorum and window of the first vote stay
+	if second.State != VOTE_PENDING || second.Count != 2 || second.Quorum != 3 {
+		t.Errorf("second Cast() = %+v, want pending 2/3", second)
+	}
+	if got := tracker.Cast(1, 12, 3, time.Second*2); got.State != VOTE_PASSED || got.Count != 3 {
+		t.Fatalf("third Cast() = %+v, want passed", got)
+	}
+	if got := tracker.Cast(1, 13, 3, time.Second*2); got.State != VOTE_DONE {
+		t.Errorf("Cast() after the quorum = %+v, want done", got)
+	}
+
+	tracker.Finish(1, true)
+	for _, pending := range []VoteResult{first, second} {
+		select {
+		case ok := <-pending.Finished:
+			if !ok {
+				t.Errorf("Finished received false, want true")
+			}
+		default:
+			t.Errorf("pending voters weren't told the vote finished")
+		}
+	}
+
+	// Expired votes start over
+	tracker.Cast(2, 10, 2, time.Second*2)
+	now = now.Add(time.Second * 3)
+	if got := tracker.Cast(2, 11, 2, time.Second*2); got.State != VOTE_PENDING || got.Count != 1 {
+		t.Errorf("Cast() after the window = %+v, want a new pending vote", got)
+	}
+
+	// Failures let voting start over
+	tracker.Cast(3, 10, 1, time.Second*2)
+	tracker.Finish(3, false)
+	if got := tracker.Cast(3, 10, 1, time.Second*2); got.State != VOTE_PASSED {
+		t.Errorf("Cast() after a failure = %+v, want passed", got)
+	}
+
+	now = now.Add(time.Second * 3)
+	tracker.Prune()
+	if _, ok := tracker.votes[2]; ok {
+		t.Errorf("Prune() kept an expired vote")
+	}
+	if _, ok := tracker.votes[1]; !ok {
+		t.Errorf("Prune() dropped a passed vote before VOTE_DONE_RETENTION")
+	}
+	now = now.Add(VOTE_DONE_RETENTION)
+	tracker.Prune()
+	if len(tracker.votes) != 0 {
+		t.Errorf("Prune() kept %d votes, want none", len(tracker.votes))
+	}
+}
+
+func TestVoteTrackerConcurrent(t *testing.T) {
+	tracker := NewVoteTracker()
+	const voters = 50
+	results := make(chan VoteResult, voters)
+	var wg sync.WaitGroup
+	for i := 0; i < voters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results <- tracker.Cast(1, discord.UserID(i+1), 10, time.Minute)
+			tracker.Prune()
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	counts := make(map[VoteState]int)
+	for result := range results {
+		counts[result.State]++
+	}
+	if counts[VOTE_PENDING] != 9 || counts[VOTE_PASSED] != 1 || counts[VOTE_DONE] != voters-10 {
+		t.Errorf("vote states = %v, want 9 pending, 1 passed and the rest done", counts)
+	}
+}